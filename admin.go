@@ -0,0 +1,417 @@
+package mutux
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// AdminPrefix default reserved path prefix under which Mutux serves its admin endpoints;
+// it can be changed per server with SetAdminPrefix.
+//
+//	GET    /__mutux/stubs           list stubs
+//	POST   /__mutux/stubs           create stub
+//	GET    /__mutux/stubs/{path}    list stubs on path
+//	PUT    /__mutux/stubs/{path}    create stub, or update the one with the same match conditions
+//	DELETE /__mutux/stubs/{path}    delete stubs on path, or only the one given by id query param, or by
+//	                                query params escaped into path
+//	DELETE /__mutux/sequences       rewind response sequences of all stubs
+//	DELETE /__mutux/sequences/{id}  rewind response sequence of stub with id
+//	GET    /__mutux/headers         list global headers
+//	PUT    /__mutux/headers/{name}  set global header, body {"value":"..."}
+//	DELETE /__mutux/headers/{name}  delete global header
+//	GET    /__mutux/settings        get settings, {"allowPUT":true,"paths":{},"unmatched":{"status":404,"closest":3}}
+//	PUT    /__mutux/settings        update settings
+//	GET    /__mutux/requests        list journal entries, filtered by method, path and since (a Seq) query params
+//	GET    /__mutux/requests/unmatched  list requests no stub or handler func answered
+//	DELETE /__mutux/requests        clear journal
+//	GET    /__mutux/verify          check stub expectations, returning a Report
+//	POST   /__mutux/order           expect stubs to be first called in order, body {"stubs":["1","2"]}
+//	GET    /__mutux/mounts          list mounts
+//	PUT    /__mutux/mounts/{prefix} mount a server directory within the file root, body {"dir":"assets","list":false}
+//	DELETE /__mutux/mounts/{prefix} unmount
+//	POST   /__mutux/reset           reset stubs, headers, settings, orders and journal
+//
+// Requests other than GET are refused with 403 while the server is read-only, see SetReadOnly.
+// Body files and mount dirs must be within the file root, see SetFileRoot.
+const AdminPrefix = "/__mutux"
+
+// Settings runtime settings exchanged with the admin API
+type Settings struct {
+	AllowPUT  *bool              `json:"allowPUT"`
+	Paths     *PathOptions       `json:"paths,omitempty"`
+	Unmatched *UnmatchedResponse `json:"unmatched,omitempty"`
+}
+
+type headerValue struct {
+	Value *string `json:"value"`
+}
+
+type stubOrder struct {
+	Stubs []string `json:"stubs"`
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, apiError{Error: msg})
+}
+
+// readJSON unmarshal request body into v, writing an error response on failure
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	err := json.NewDecoder(r.Body).Decode(v)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Error unmarshalling body: %s", err.Error()))
+		return false
+	}
+	return true
+}
+
+// remoteFile return file, named in a request to the HTTP API, resolved against root, the file root;
+// an error if it is outside root, or no root is set
+func remoteFile(root, file string) (string, error) {
+	if root == "" {
+		return "", fmt.Errorf("server file %s cannot be read through the HTTP API unless a file root is set", file)
+	}
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(root, file)
+	}
+	resolved := resolveExisting(file)
+	rel, err := filepath.Rel(root, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("server file %s is outside the file root", file)
+	}
+	return resolved, nil
+}
+
+// resolveExisting return file with the symlinks of its longest existing part resolved, so that a file
+// that does not exist is judged by the directory it would be in
+func resolveExisting(file string) string {
+	file = filepath.Clean(file)
+	missing := ""
+	for {
+		if resolved, err := filepath.EvalSymlinks(file); err == nil {
+			return filepath.Join(resolved, missing)
+		}
+		parent := filepath.Dir(file)
+		if parent == file {
+			return filepath.Join(file, missing)
+		}
+		missing = filepath.Join(filepath.Base(file), missing)
+		file = parent
+	}
+}
+
+// remoteResponse resolve the body file of resp, sent to the HTTP API, with remoteFile
+func remoteResponse(root string, resp Response) (Response, error) {
+	if resp.BodyFile == "" {
+		return resp, nil
+	}
+	file, err := remoteFile(root, resp.BodyFile)
+	resp.BodyFile = file
+	return resp, err
+}
+
+// remoteMessage resolve the body files of msg, sent to the HTTP API, with remoteFile
+func remoteMessage(root string, msg Message) (Message, error) {
+	var err error
+	msg.Response, err = remoteResponse(root, msg.Response)
+	if err != nil {
+		return msg, err
+	}
+	for i := range msg.Responses {
+		msg.Responses[i], err = remoteResponse(root, msg.Responses[i])
+		if err != nil {
+			return msg, fmt.Errorf("response %d: %s", i+1, err.Error())
+		}
+	}
+	return msg, nil
+}
+
+// readStub read a stub from request body, and prepare it for the store
+func readStub(w http.ResponseWriter, r *http.Request, store *stubStore, path string) (string, Stub, bool) {
+	stub := Stub{}
+	if !readJSON(w, r, &stub) {
+		return "", stub, false
+	}
+	if path == "" {
+		path = stub.Path
+	}
+	msg, err := remoteMessage(store.filesRoot(), stub.Message)
+	if err != nil {
+		writeError(w, http.StatusForbidden, fmt.Sprintf("Error: %s", err.Error()))
+		return "", stub, false
+	}
+	path, msg, err = prepareStub(path, msg)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Error: %s", err.Error()))
+		return "", stub, false
+	}
+	stub.Message = msg
+	return path, stub, true
+}
+
+// adminFuncs admin handler funcs to manage stubs, headers, settings, mounts and the request journal
+func adminFuncs(prefix string, store *stubStore, j *journal, mnts *mounts) []Handlerfunc {
+	GETstubsfunc := func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, store.stubs())
+	}
+	POSTstubsfunc := func(w http.ResponseWriter, r *http.Request) {
+		path, stub, ok := readStub(w, r, store, "")
+		if !ok {
+			return
+		}
+		if store.hasMsg(path, stub.Message) {
+			writeError(w, http.StatusConflict, fmt.Sprintf("stub /%s with the same match conditions already exists", path))
+			return
+		}
+		writeJSON(w, http.StatusCreated, store.setMsg(path, stub.Message))
+	}
+	GETstubfunc := func(w http.ResponseWriter, r *http.Request) {
+		path := trimPath(mux.Vars(r)["path"])
+		stubs := store.stubsAt(path)
+		if len(stubs) == 0 {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("stub /%s not found", path))
+			return
+		}
+		writeJSON(w, http.StatusOK, stubs)
+	}
+	PUTstubfunc := func(w http.ResponseWriter, r *http.Request) {
+		path, stub, ok := readStub(w, r, store, mux.Vars(r)["path"])
+		if !ok {
+			return
+		}
+		writeJSON(w, http.StatusOK, store.setMsg(path, stub.Message))
+	}
+	DELETEstubfunc := func(w http.ResponseWriter, r *http.Request) {
+		raw := mux.Vars(r)["path"]
+		path := trimPath(raw)
+		id := r.URL.Query().Get("id")
+		exists := false
+		if strings.Contains(raw, "?") {
+			// query params escaped into the path select the stub added with exactly those params, as with DelPathMsg
+			match, err := queryMatch(raw)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("Error: %s", err.Error()))
+				return
+			}
+			exists = store.delMatch(path, match)
+		} else {
+			for _, stub := range store.stubsAt(path) {
+				if id == "" || stub.ID == id {
+					exists = store.delID(stub.ID) || exists
+				}
+			}
+		}
+		if !exists {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("stub /%s not found", path))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+	DELETEsequencesfunc := func(w http.ResponseWriter, r *http.Request) {
+		store.resetPosition("")
+		w.WriteHeader(http.StatusNoContent)
+	}
+	DELETEsequencefunc := func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		if !store.resetPosition(id) {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("stub with id %s not found", id))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+	GETheadersfunc := func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, store.headerCopy())
+	}
+	PUTheaderfunc := func(w http.ResponseWriter, r *http.Request) {
+		header := headerValue{}
+		if !readJSON(w, r, &header) {
+			return
+		}
+		if header.Value == nil {
+			writeError(w, http.StatusBadRequest, "Error: value is empty")
+			return
+		}
+		store.setHeader(mux.Vars(r)["name"], *header.Value)
+		writeJSON(w, http.StatusOK, store.headerCopy())
+	}
+	DELETEheaderfunc := func(w http.ResponseWriter, r *http.Request) {
+		store.delHeader(mux.Vars(r)["name"])
+		w.WriteHeader(http.StatusNoContent)
+	}
+	GETsettingsfunc := func(w http.ResponseWriter, r *http.Request) {
+		allowPUT := store.putAllowed()
+		paths := store.pathOptions()
+		unmatched := store.unmatchedResponse()
+		writeJSON(w, http.StatusOK, Settings{AllowPUT: &allowPUT, Paths: &paths, Unmatched: &unmatched})
+	}
+	PUTsettingsfunc := func(w http.ResponseWriter, r *http.Request) {
+		settings := Settings{}
+		if !readJSON(w, r, &settings) {
+			return
+		}
+		if settings.Paths != nil {
+			err := settings.Paths.validate()
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "paths: "+err.Error())
+				return
+			}
+		}
+		if settings.Unmatched != nil && settings.Unmatched.Response != nil {
+			resp, err := remoteResponse(store.filesRoot(), *settings.Unmatched.Response)
+			if err != nil {
+				writeError(w, http.StatusForbidden, "unmatched: "+err.Error())
+				return
+			}
+			settings.Unmatched.Response = &resp
+		}
+		if settings.Unmatched != nil {
+			unmatched, err := prepareUnmatched(*settings.Unmatched)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "unmatched: "+err.Error())
+				return
+			}
+			store.setUnmatched(unmatched)
+		}
+		if settings.Paths != nil {
+			store.setPaths(*settings.Paths)
+		}
+		if settings.AllowPUT != nil {
+			store.setAllowPUT(*settings.AllowPUT)
+		}
+		GETsettingsfunc(w, r)
+	}
+	GETrequestsfunc := func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		entries := j.find(q.Get("method"), q.Get("path"), store.pathOptions())
+		if since := q.Get("since"); since != "" {
+			seq, err := strconv.ParseInt(since, 10, 64)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("Error: invalid since %q", since))
+				return
+			}
+			entries = entriesSince(entries, seq)
+		}
+		writeJSON(w, http.StatusOK, entries)
+	}
+	GETunmatchedfunc := func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, j.findUnmatched())
+	}
+	DELETErequestsfunc := func(w http.ResponseWriter, r *http.Request) {
+		j.reset()
+		w.WriteHeader(http.StatusNoContent)
+	}
+	GETverifyfunc := func(w http.ResponseWriter, r *http.Request) {
+		stubs, orders, calls := store.expectations()
+		writeJSON(w, http.StatusOK, verify(stubs, orders, calls, j.find("", "", PathOptions{}), store.pathOptions()))
+	}
+	POSTorderfunc := func(w http.ResponseWriter, r *http.Request) {
+		order := stubOrder{}
+		if !readJSON(w, r, &order) {
+			return
+		}
+		err := store.addOrder(order.Stubs)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+	GETmountsfunc := func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, mnts.all())
+	}
+	PUTmountfunc := func(w http.ResponseWriter, r *http.Request) {
+		mt := Mount{}
+		if !readJSON(w, r, &mt) {
+			return
+		}
+		mt.Prefix = mux.Vars(r)["prefix"]
+		if mt.Dir != "" {
+			dir, err := remoteFile(store.filesRoot(), mt.Dir)
+			if err != nil {
+				writeError(w, http.StatusForbidden, fmt.Sprintf("Error: %s", err.Error()))
+				return
+			}
+			mt.Dir = dir
+		}
+		mt, err := prepareMount(mt)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("Error: %s", err.Error()))
+			return
+		}
+		mnts.set(mt)
+		writeJSON(w, http.StatusOK, mt)
+	}
+	DELETEmountfunc := func(w http.ResponseWriter, r *http.Request) {
+		prefix := mux.Vars(r)["prefix"]
+		if !mnts.del(prefix) {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("mount /%s not found", trimPath(prefix)))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+	POSTresetfunc := func(w http.ResponseWriter, r *http.Request) {
+		store.reset()
+		j.reset()
+		w.WriteHeader(http.StatusNoContent)
+	}
+	notfoundfunc := func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("no admin endpoint %s %s", r.Method, r.URL.Path))
+	}
+
+	funcs := []Handlerfunc{
+		Handlerfunc{Route: prefix + "/stubs", Function: &GETstubsfunc, Methods: []string{"GET"}},
+		Handlerfunc{Route: prefix + "/stubs", Function: &POSTstubsfunc, Methods: []string{"POST"}},
+		Handlerfunc{Route: prefix + "/stubs/{path:.+}", Function: &GETstubfunc, Methods: []string{"GET"}},
+		Handlerfunc{Route: prefix + "/stubs/{path:.+}", Function: &PUTstubfunc, Methods: []string{"PUT"}},
+		Handlerfunc{Route: prefix + "/stubs/{path:.+}", Function: &DELETEstubfunc, Methods: []string{"DELETE"}},
+		Handlerfunc{Route: prefix + "/sequences", Function: &DELETEsequencesfunc, Methods: []string{"DELETE"}},
+		Handlerfunc{Route: prefix + "/sequences/{id}", Function: &DELETEsequencefunc, Methods: []string{"DELETE"}},
+		Handlerfunc{Route: prefix + "/headers", Function: &GETheadersfunc, Methods: []string{"GET"}},
+		Handlerfunc{Route: prefix + "/headers/{name}", Function: &PUTheaderfunc, Methods: []string{"PUT"}},
+		Handlerfunc{Route: prefix + "/headers/{name}", Function: &DELETEheaderfunc, Methods: []string{"DELETE"}},
+		Handlerfunc{Route: prefix + "/settings", Function: &GETsettingsfunc, Methods: []string{"GET"}},
+		Handlerfunc{Route: prefix + "/settings", Function: &PUTsettingsfunc, Methods: []string{"PUT"}},
+		Handlerfunc{Route: prefix + "/requests", Function: &GETrequestsfunc, Methods: []string{"GET"}},
+		Handlerfunc{Route: prefix + "/requests", Function: &DELETErequestsfunc, Methods: []string{"DELETE"}},
+		Handlerfunc{Route: prefix + "/requests/unmatched", Function: &GETunmatchedfunc, Methods: []string{"GET"}},
+		Handlerfunc{Route: prefix + "/verify", Function: &GETverifyfunc, Methods: []string{"GET"}},
+		Handlerfunc{Route: prefix + "/order", Function: &POSTorderfunc, Methods: []string{"POST"}},
+		Handlerfunc{Route: prefix + "/mounts", Function: &GETmountsfunc, Methods: []string{"GET"}},
+		Handlerfunc{Route: prefix + "/mounts/{prefix:.*}", Function: &PUTmountfunc, Methods: []string{"PUT"}},
+		Handlerfunc{Route: prefix + "/mounts/{prefix:.*}", Function: &DELETEmountfunc, Methods: []string{"DELETE"}},
+		Handlerfunc{Route: prefix + "/reset", Function: &POSTresetfunc, Methods: []string{"POST"}},
+		// anything else under the admin prefix is an unknown endpoint, never a stub
+		Handlerfunc{Route: prefix + "/{rest:.*}", Function: &notfoundfunc},
+	}
+	// while read-only, refuse all but GET
+	for i, h := range funcs {
+		if len(h.Methods) == 0 || h.Methods[0] == "GET" {
+			continue
+		}
+		f := *h.Function
+		guarded := func(w http.ResponseWriter, r *http.Request) {
+			if store.isReadOnly() {
+				writeError(w, http.StatusForbidden, "Error: admin API is read-only")
+				return
+			}
+			f(w, r)
+		}
+		funcs[i].Function = &guarded
+	}
+	return funcs
+}
@@ -0,0 +1,179 @@
+package mutux
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// put send body to the server at path, returning the response status and body
+func put(t *testing.T, m *Mutux, path, body string) (int, string) {
+	req, err := http.NewRequest(http.MethodPut, m.URL()+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, string(b)
+}
+
+func TestRemoteFiles(t *testing.T) {
+	m := newTestServer(t)
+
+	dir, err := ioutil.TempDir("", "mutux-root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	root := filepath.Join(dir, "root")
+	secret := filepath.Join(dir, "secret.txt")
+	err = os.Mkdir(root, 0755)
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(root, "body.txt"), []byte("body"), 0644)
+	}
+	if err == nil {
+		err = ioutil.WriteFile(secret, []byte("secret"), 0644)
+	}
+	if err == nil {
+		err = os.Symlink(secret, filepath.Join(root, "link"))
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{AdminPrefix + "/stubs/leak", "/leak"} {
+		if status, body := put(t, m, path, `{"bodyFile":"`+secret+`"}`); status != http.StatusForbidden {
+			t.Errorf("PUT %s without file root = %d %s, want 403", path, status, body)
+		}
+	}
+	status, body := put(t, m, AdminPrefix+"/settings", `{"unmatched":{"response":{"bodyFile":"`+secret+`"}}}`)
+	if status != http.StatusForbidden {
+		t.Errorf("unmatched body file without file root = %d %s, want 403", status, body)
+	}
+
+	err = m.SetFileRoot(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{secret, "../secret.txt", "link"} {
+		if status, body := put(t, m, AdminPrefix+"/stubs/leak", `{"bodyFile":"`+file+`"}`); status != http.StatusForbidden {
+			t.Errorf("PUT body file %s outside the file root = %d %s, want 403", file, status, body)
+		}
+	}
+	if status, body := put(t, m, AdminPrefix+"/stubs/ok", `{"bodyFile":"body.txt"}`); status != http.StatusOK {
+		t.Fatalf("PUT body file within the file root = %d %s, want 200", status, body)
+	}
+	resp, err := http.Get(m.URL() + "/ok")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(b) != "body" {
+		t.Errorf("GET /ok = %q, want the body file", b)
+	}
+
+	// stubs added from Go may read any file
+	_, err = m.AddStub("go", Message{Response: Response{BodyFile: secret}})
+	if err != nil {
+		t.Errorf("AddStub with body file outside the file root: %s", err.Error())
+	}
+
+	for _, d := range []string{dir, "..", "/"} {
+		if status, body := put(t, m, AdminPrefix+"/mounts/out", `{"dir":"`+d+`"}`); status != http.StatusForbidden {
+			t.Errorf("PUT mount dir %s outside the file root = %d %s, want 403", d, status, body)
+		}
+	}
+	if status, body := put(t, m, AdminPrefix+"/mounts/in", `{"dir":"."}`); status != http.StatusOK {
+		t.Fatalf("PUT mount dir within the file root = %d %s, want 200", status, body)
+	}
+	resp, err = http.Get(m.URL() + "/in/body.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(b) != "body" {
+		t.Errorf("GET /in/body.txt = %q, want the mounted file", b)
+	}
+	m.SetFileRoot("")
+	if status, body := put(t, m, AdminPrefix+"/mounts/in", `{"dir":"."}`); status != http.StatusForbidden {
+		t.Errorf("PUT mount without file root = %d %s, want 403", status, body)
+	}
+}
+
+// TestRemoteFileMissing check that files that do not exist are judged by the directory they would be in
+func TestRemoteFileMissing(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mutux-root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// the file root as SetFileRoot keeps it, with symlinks resolved
+	dir, err = filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := filepath.Join(dir, "root")
+	err = os.MkdirAll(filepath.Join(root, "in"), 0755)
+	if err == nil {
+		err = os.Symlink(dir, filepath.Join(root, "out"))
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		file string
+		ok   bool
+	}{
+		{"missing.txt", true},
+		{"in/missing.txt", true},
+		{"new/dir/missing.txt", true},
+		{"out/missing.txt", false},
+		{"out/new/missing.txt", false},
+		{"../missing.txt", false},
+	} {
+		_, err := remoteFile(root, c.file)
+		if (err == nil) != c.ok {
+			t.Errorf("remoteFile(%s) = %v, want allowed %v", c.file, err, c.ok)
+		}
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	m := newTestServer(t)
+	m.SetReadOnly(true)
+
+	if status, body := put(t, m, AdminPrefix+"/stubs/a", `{"message":"a"}`); status != http.StatusForbidden {
+		t.Errorf("admin PUT while read-only = %d %s, want 403", status, body)
+	}
+	if status, body := put(t, m, AdminPrefix+"/settings", `{"allowPUT":true}`); status != http.StatusForbidden {
+		t.Errorf("settings PUT while read-only = %d %s, want 403", status, body)
+	}
+	if status, _ := put(t, m, "/b", `{"message":"b"}`); status != http.StatusNotFound {
+		t.Errorf("PUT to stubbed path while read-only = %d, want 404", status)
+	}
+	if len(m.Stubs()) != 0 {
+		t.Errorf("stubs added while read-only: %v", m.Stubs())
+	}
+	resp, err := http.Get(m.URL() + AdminPrefix + "/stubs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("admin GET while read-only = %d, want 200", resp.StatusCode)
+	}
+
+	m.SetReadOnly(false)
+	if status, body := put(t, m, AdminPrefix+"/stubs/a", `{"message":"a"}`); status != http.StatusOK {
+		t.Errorf("admin PUT after read-only = %d %s, want 200", status, body)
+	}
+}
@@ -0,0 +1,577 @@
+// Package client drives a Mutux server running in another process through its admin API.
+//
+// Client implements mutux.Controller, so the same test code works against an embedded Mutux
+// and a remote one:
+//
+//	var m mutux.Controller = client.New("http://localhost:6666")
+//	m.AddPathMsgAndStatus("hello", "Hello, world!", 200)
+//	if err := m.(*client.Client).Err(); err != nil { ... }
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dzhoou/mutux"
+)
+
+// DefaultTimeout timeout of requests to the admin API unless HTTPClient is replaced
+const DefaultTimeout = 10 * time.Second
+
+// Client client of the admin API of a remote Mutux.
+//
+// Methods mirror those of mutux.Mutux. Those that return no error in Mutux record failures
+// instead, to be checked with Err.
+type Client struct {
+	// URL base URL of the server, e.g. "http://localhost:6666"
+	URL string
+	// AdminPrefix path prefix of the admin API, as set on the server with SetAdminPrefix
+	AdminPrefix string
+	HTTPClient  *http.Client
+	mu          sync.Mutex
+	err         error
+}
+
+var _ mutux.Controller = (*Client)(nil)
+
+// Error error response of the admin API
+type Error struct {
+	Method  string
+	URL     string
+	Status  int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s %s: %d %s", e.Method, e.URL, e.Status, e.Message)
+}
+
+// New creates a client of the Mutux server at url
+func New(url string) *Client {
+	return &Client{
+		URL:         strings.TrimRight(url, "/"),
+		AdminPrefix: mutux.AdminPrefix,
+		HTTPClient:  &http.Client{Timeout: DefaultTimeout},
+	}
+}
+
+// Err return the first failure recorded by methods that return no error since Err was last called, and clear it
+func (c *Client) Err() error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	err := c.err
+	c.err = nil
+	return err
+}
+
+func (c *Client) fail(err error) {
+	if err == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err == nil {
+		c.err = err
+	}
+}
+
+// do send body as JSON to the admin endpoint at path, and decode the response into out
+func (c *Client) do(method, path string, body, out interface{}) error {
+	var b []byte
+	var err error
+	if body != nil {
+		b, err = json.Marshal(body)
+		if err != nil {
+			return err
+		}
+	}
+	u := c.URL + "/" + strings.Trim(c.AdminPrefix, "/") + path
+	req, err := http.NewRequest(method, u, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		apiErr := struct {
+			Error string `json:"error"`
+		}{}
+		if json.Unmarshal(b, &apiErr) != nil || apiErr.Error == "" {
+			apiErr.Error = strings.TrimSpace(string(b))
+		}
+		return &Error{Method: method, URL: u, Status: resp.StatusCode, Message: apiErr.Error}
+	}
+	if out != nil && len(b) > 0 {
+		return json.Unmarshal(b, out)
+	}
+	return nil
+}
+
+// isNotFound return whether err is a 404 from the admin API
+func isNotFound(err error) bool {
+	apiErr, ok := err.(*Error)
+	return ok && apiErr.Status == http.StatusNotFound
+}
+
+// escapePath escape a stub path for use in an admin URL, keeping its slashes;
+// query params are escaped into the path, where the server reads them as match conditions
+func escapePath(path string) string {
+	segments := strings.Split(strings.TrimLeft(path, "/"), "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return strings.Join(segments, "/")
+}
+
+// AddPathMsg add message to a URL path
+func (c *Client) AddPathMsg(path, msg string) {
+	if c == nil {
+		return
+	}
+	c.AddPathMsgAndStatus(path, msg, 200)
+}
+
+// AddPathMsgAndStatus add message to a URL path, with specified status code
+func (c *Client) AddPathMsgAndStatus(path, msg string, status int) {
+	if c == nil {
+		return
+	}
+	_, err := c.AddStub(path, mutux.Message{
+		Response: mutux.Response{
+			Msg:    &msg,
+			Status: &status,
+		},
+	})
+	c.fail(err)
+}
+
+// AddMethodPathMsg add message to a URL path, returned only for requests with method
+func (c *Client) AddMethodPathMsg(method, path, msg string) {
+	if c == nil {
+		return
+	}
+	c.AddMethodPathMsgAndStatus(method, path, msg, 200)
+}
+
+// AddMethodPathMsgAndStatus add message to a URL path, with specified status code, returned only for requests with method
+func (c *Client) AddMethodPathMsgAndStatus(method, path, msg string, status int) {
+	if c == nil {
+		return
+	}
+	_, err := c.AddStub(path, mutux.Message{
+		Response: mutux.Response{
+			Msg:    &msg,
+			Status: &status,
+		},
+		Match: mutux.Match{Method: method},
+	})
+	c.fail(err)
+}
+
+// AddPathMsgAndHeaders add message to a URL path, with specified status code and response headers
+func (c *Client) AddPathMsgAndHeaders(path, msg string, status int, headers map[string]string) {
+	if c == nil {
+		return
+	}
+	values := mutux.HeaderValues{}
+	for k, v := range headers {
+		values[k] = []string{v}
+	}
+	_, err := c.AddStub(path, mutux.Message{
+		Response: mutux.Response{
+			Msg:     &msg,
+			Status:  &status,
+			Headers: values,
+		},
+	})
+	c.fail(err)
+}
+
+// AddStub add message to a URL path, returned for requests satisfying its match conditions.
+// Query params in path are added to the conditions as exact values.
+func (c *Client) AddStub(path string, msg mutux.Message) (mutux.Stub, error) {
+	if c == nil {
+		return mutux.Stub{}, nil
+	}
+	stub := mutux.Stub{}
+	err := c.do(http.MethodPut, "/stubs/"+escapePath(path), msg, &stub)
+	return stub, err
+}
+
+// StubsAt return the stubs on path; none if there are none
+func (c *Client) StubsAt(path string) ([]mutux.Stub, error) {
+	if c == nil {
+		return nil, nil
+	}
+	stubs := []mutux.Stub{}
+	err := c.do(http.MethodGet, "/stubs/"+escapePath(path), nil, &stubs)
+	if isNotFound(err) {
+		return nil, nil
+	}
+	return stubs, err
+}
+
+// delStubs delete the stubs on path for which del returns true
+func (c *Client) delStubs(path string, del func(mutux.Stub) bool) {
+	stubs, err := c.StubsAt(path)
+	if err != nil {
+		c.fail(err)
+		return
+	}
+	for _, stub := range stubs {
+		if del(stub) {
+			err = c.do(http.MethodDelete, "/stubs/"+escapePath(path)+"?id="+url.QueryEscape(stub.ID), nil, nil)
+			if !isNotFound(err) {
+				c.fail(err)
+			}
+		}
+	}
+}
+
+// DelPathMsg delete msg from a URL path. If path has query params, only the message
+// added with exactly those params is deleted; otherwise all messages on path are.
+func (c *Client) DelPathMsg(path string) {
+	if c == nil {
+		return
+	}
+	err := c.do(http.MethodDelete, "/stubs/"+escapePath(path), nil, nil)
+	if !isNotFound(err) {
+		c.fail(err)
+	}
+}
+
+// DelMethodPathMsg delete all messages on a URL path added for method
+func (c *Client) DelMethodPathMsg(method, path string) {
+	if c == nil {
+		return
+	}
+	c.delStubs(path, func(stub mutux.Stub) bool {
+		return stub.Method == strings.ToUpper(method)
+	})
+}
+
+// DelStub delete the stub with id, returning whether it existed
+func (c *Client) DelStub(id string) bool {
+	if c == nil {
+		return false
+	}
+	for _, stub := range c.Stubs() {
+		if stub.ID == id {
+			err := c.do(http.MethodDelete, "/stubs/"+escapePath(stub.Path)+"?id="+url.QueryEscape(id), nil, nil)
+			if isNotFound(err) {
+				return false
+			}
+			c.fail(err)
+			return err == nil
+		}
+	}
+	return false
+}
+
+// ResetSequence rewind the response sequence of the stub with id to its first response, returning whether the stub exists
+func (c *Client) ResetSequence(id string) bool {
+	if c == nil {
+		return false
+	}
+	err := c.do(http.MethodDelete, "/sequences/"+url.PathEscape(id), nil, nil)
+	if isNotFound(err) {
+		return false
+	}
+	c.fail(err)
+	return err == nil
+}
+
+// ResetSequences rewind the response sequences of all stubs to their first response
+func (c *Client) ResetSequences() {
+	if c == nil {
+		return
+	}
+	c.fail(c.do(http.MethodDelete, "/sequences", nil, nil))
+}
+
+// Stubs return a snapshot of all stubs, ordered by path
+func (c *Client) Stubs() []mutux.Stub {
+	if c == nil {
+		return nil
+	}
+	stubs := []mutux.Stub{}
+	err := c.do(http.MethodGet, "/stubs", nil, &stubs)
+	if err != nil {
+		c.fail(err)
+		return nil
+	}
+	return stubs
+}
+
+// AddHeader add header to all stub responses
+func (c *Client) AddHeader(name, value string) {
+	if c == nil {
+		return
+	}
+	c.fail(c.do(http.MethodPut, "/headers/"+url.PathEscape(name), map[string]string{"value": value}, nil))
+}
+
+// DelHeader delete header from all stub responses
+func (c *Client) DelHeader(name string) {
+	if c == nil {
+		return
+	}
+	c.fail(c.do(http.MethodDelete, "/headers/"+url.PathEscape(name), nil, nil))
+}
+
+// Headers return a snapshot of headers added to all stub responses
+func (c *Client) Headers() map[string]string {
+	if c == nil {
+		return nil
+	}
+	headers := map[string]string{}
+	err := c.do(http.MethodGet, "/headers", nil, &headers)
+	if err != nil {
+		c.fail(err)
+		return nil
+	}
+	return headers
+}
+
+func (c *Client) setAllowPUT(allow bool) {
+	c.fail(c.do(http.MethodPut, "/settings", mutux.Settings{AllowPUT: &allow}, nil))
+}
+
+// EnablePUT enable modifying path message by PUT
+func (c *Client) EnablePUT() {
+	if c == nil {
+		return
+	}
+	c.setAllowPUT(true)
+}
+
+// DisablePUT disable modifying path message by PUT
+func (c *Client) DisablePUT() {
+	if c == nil {
+		return
+	}
+	c.setAllowPUT(false)
+}
+
+// PUTAllowed return whether modifying path message by PUT is enabled
+func (c *Client) PUTAllowed() bool {
+	if c == nil {
+		return false
+	}
+	settings := mutux.Settings{}
+	err := c.do(http.MethodGet, "/settings", nil, &settings)
+	if err != nil {
+		c.fail(err)
+		return false
+	}
+	return settings.AllowPUT != nil && *settings.AllowPUT
+}
+
+// SetPathOptions set how request paths are compared with stub paths; stubs added already are kept
+func (c *Client) SetPathOptions(o mutux.PathOptions) error {
+	if c == nil {
+		return nil
+	}
+	return c.do(http.MethodPut, "/settings", mutux.Settings{Paths: &o}, nil)
+}
+
+// PathOptions return how request paths are compared with stub paths
+func (c *Client) PathOptions() mutux.PathOptions {
+	if c == nil {
+		return mutux.PathOptions{}
+	}
+	settings := mutux.Settings{}
+	err := c.do(http.MethodGet, "/settings", nil, &settings)
+	if err != nil || settings.Paths == nil {
+		c.fail(err)
+		return mutux.PathOptions{}
+	}
+	return *settings.Paths
+}
+
+// SetUnmatchedResponse set the answer to requests that no stub or handler func matches
+func (c *Client) SetUnmatchedResponse(u mutux.UnmatchedResponse) error {
+	if c == nil {
+		return nil
+	}
+	return c.do(http.MethodPut, "/settings", mutux.Settings{Unmatched: &u}, nil)
+}
+
+// UnmatchedResponse return the answer to requests that no stub or handler func matches
+func (c *Client) UnmatchedResponse() mutux.UnmatchedResponse {
+	if c == nil {
+		return mutux.UnmatchedResponse{}
+	}
+	settings := mutux.Settings{}
+	err := c.do(http.MethodGet, "/settings", nil, &settings)
+	if err != nil || settings.Unmatched == nil {
+		c.fail(err)
+		return mutux.UnmatchedResponse{}
+	}
+	return *settings.Unmatched
+}
+
+// Requests return a copy of all requests in the journal, oldest first
+func (c *Client) Requests() []mutux.JournalEntry {
+	if c == nil {
+		return nil
+	}
+	return c.FindRequests("", "")
+}
+
+// FindRequests return requests in the journal with method and path; empty method or path match any
+func (c *Client) FindRequests(method, path string) []mutux.JournalEntry {
+	if c == nil {
+		return nil
+	}
+	return c.FindRequestsSince(method, path, 0)
+}
+
+// FindRequestsSince return requests in the journal with method and path, numbered after seq;
+// polling with the Seq of the last entry seen returns each request once
+func (c *Client) FindRequestsSince(method, path string, seq int64) []mutux.JournalEntry {
+	if c == nil {
+		return nil
+	}
+	query := url.Values{}
+	if method != "" {
+		query.Set("method", method)
+	}
+	if path != "" {
+		query.Set("path", path)
+	}
+	if seq > 0 {
+		query.Set("since", strconv.FormatInt(seq, 10))
+	}
+	endpoint := "/requests"
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+	entries := []mutux.JournalEntry{}
+	err := c.do(http.MethodGet, endpoint, nil, &entries)
+	if err != nil {
+		c.fail(err)
+		return nil
+	}
+	return entries
+}
+
+// UnmatchedRequests return requests that no stub or handler func answered, oldest first, each with
+// the stubs that came closest
+func (c *Client) UnmatchedRequests() []mutux.JournalEntry {
+	if c == nil {
+		return nil
+	}
+	entries := []mutux.JournalEntry{}
+	err := c.do(http.MethodGet, "/requests/unmatched", nil, &entries)
+	if err != nil {
+		c.fail(err)
+		return nil
+	}
+	return entries
+}
+
+// ResetJournal delete all requests from the journal
+func (c *Client) ResetJournal() {
+	if c == nil {
+		return
+	}
+	c.fail(c.do(http.MethodDelete, "/requests", nil, nil))
+}
+
+// AddMount serve a directory of the server, within its file root, under a path prefix, to requests no stub matches;
+// an fs.FS cannot be mounted remotely
+func (c *Client) AddMount(mt mutux.Mount) error {
+	if c == nil {
+		return nil
+	}
+	if mt.FS != nil {
+		return fmt.Errorf("Failed to add mount: fs cannot be mounted on a remote server")
+	}
+	return c.do(http.MethodPut, "/mounts/"+escapePath(mt.Prefix), mt, nil)
+}
+
+// MountDir serve the directory dir of the server under a path prefix, without directory listings
+func (c *Client) MountDir(prefix, dir string) error {
+	return c.AddMount(mutux.Mount{Prefix: prefix, Dir: dir})
+}
+
+// Unmount stop serving the mount on prefix, returning whether it existed
+func (c *Client) Unmount(prefix string) bool {
+	if c == nil {
+		return false
+	}
+	err := c.do(http.MethodDelete, "/mounts/"+escapePath(prefix), nil, nil)
+	if isNotFound(err) {
+		return false
+	}
+	c.fail(err)
+	return err == nil
+}
+
+// Mounts return the mounts, longest prefix first
+func (c *Client) Mounts() []mutux.Mount {
+	if c == nil {
+		return nil
+	}
+	mounts := []mutux.Mount{}
+	err := c.do(http.MethodGet, "/mounts", nil, &mounts)
+	if err != nil {
+		c.fail(err)
+		return nil
+	}
+	return mounts
+}
+
+// ExpectOrder expect the stubs with ids to be first called in that order, checked by Verify
+func (c *Client) ExpectOrder(ids ...string) error {
+	if c == nil {
+		return nil
+	}
+	return c.do(http.MethodPost, "/order", map[string][]string{"stubs": ids}, nil)
+}
+
+// Verify check the expectations declared on stubs against the number of requests each answered,
+// and the orders declared with ExpectOrder against the requests in the journal. Failing to reach
+// the server is reported as a failure too.
+func (c *Client) Verify() mutux.Report {
+	if c == nil {
+		return mutux.Report{}
+	}
+	report := mutux.Report{}
+	err := c.do(http.MethodGet, "/verify", nil, &report)
+	if err != nil {
+		c.fail(err)
+		return mutux.Report{Failures: []mutux.Failure{{Expected: "expectations verified", Actual: err.Error()}}}
+	}
+	return report
+}
+
+// Reset delete all path messages and journal entries, and restore default headers and PUT setting
+func (c *Client) Reset() {
+	if c == nil {
+		return
+	}
+	c.fail(c.do(http.MethodPost, "/reset", nil, nil))
+}
@@ -0,0 +1,55 @@
+package client
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/dzhoou/mutux/mutuxtest"
+)
+
+// TestClient drive a server through the admin API as the CLI does
+func TestClient(t *testing.T) {
+	m := mutuxtest.New(t)
+	c := New(m.URL())
+	// a connection a client dialed but did not use would hold Stop until it times out
+	defer http.DefaultClient.CloseIdleConnections()
+	defer c.HTTPClient.CloseIdleConnections()
+
+	c.AddPathMsg("items?page=1", "one")
+	c.AddPathMsg("items?page=2", "two")
+	c.AddPathMsg("items", "all")
+	c.DelPathMsg("items?page=1")
+	if err := c.Err(); err != nil {
+		t.Fatal(err)
+	}
+	stubs, err := c.StubsAt("items")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stubs) != 2 {
+		t.Errorf("StubsAt(items) = %d stubs after deleting page=1, want 2", len(stubs))
+	}
+	stubs, err = c.StubsAt("missing")
+	if err != nil || len(stubs) != 0 {
+		t.Errorf("StubsAt(missing) = %v, %v, want none", stubs, err)
+	}
+
+	for _, page := range []string{"1", "2", "2"} {
+		resp, err := http.Get(m.URL() + "/items?page=" + page)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	entries := c.FindRequestsSince("", "items", 0)
+	if len(entries) != 3 {
+		t.Fatalf("FindRequestsSince(0) = %d entries, want 3", len(entries))
+	}
+	since := c.FindRequestsSince("", "items", entries[0].Seq)
+	if len(since) != 2 || since[0].Seq != entries[1].Seq {
+		t.Errorf("FindRequestsSince(%d) = %v, want the last 2 entries", entries[0].Seq, since)
+	}
+	if err := c.Err(); err != nil {
+		t.Fatal(err)
+	}
+}
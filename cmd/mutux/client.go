@@ -0,0 +1,342 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"unicode/utf8"
+
+	"github.com/dzhoou/mutux"
+	"github.com/dzhoou/mutux/client"
+)
+
+// clientFlags add the flags locating the server to fs, and return a func creating a client
+// of its admin API once fs is parsed
+func clientFlags(fs *flag.FlagSet) func() *client.Client {
+	defaultURL := os.Getenv("MUTUX_URL")
+	if defaultURL == "" {
+		defaultURL = "http://localhost:6666"
+	}
+	u := fs.String("url", defaultURL, "URL of the Mutux server; defaults to $MUTUX_URL")
+	prefix := fs.String("admin-prefix", mutux.AdminPrefix, "path prefix of the admin API")
+	return func() *client.Client {
+		c := client.New(*u)
+		c.AdminPrefix = *prefix
+		return c
+	}
+}
+
+// stringList flag that may be given several times
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ", ")
+}
+
+func (s *stringList) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+// parseArgs parse fs from args, allowing flags after positional arguments, and return the positional ones
+func parseArgs(fs *flag.FlagSet, args []string) []string {
+	positional := []string{}
+	for {
+		fs.Parse(args)
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+func stubCmd(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("expected stub set, ls or rm")
+	}
+	switch args[0] {
+	case "set":
+		return stubSetCmd(args[1:])
+	case "ls":
+		return stubLsCmd(args[1:])
+	case "rm":
+		return stubRmCmd(args[1:])
+	}
+	return fmt.Errorf("unknown command stub %s", args[0])
+}
+
+// stubSetCmd add a stub, or replace the one on the same path with the same match conditions
+func stubSetCmd(args []string) error {
+	fs := flag.NewFlagSet("stub set", flag.ExitOnError)
+	newClient := clientFlags(fs)
+	method := fs.String("method", "", "HTTP method the stub answers; any if empty")
+	status := fs.Int("status", http.StatusOK, "response status")
+	body := fs.String("body", "", "response body, or @file to read it from file, or @- from stdin; sent as bytes unless UTF-8")
+	bodySize := fs.Int64("body-size", 0, "respond with a synthetic body of this many bytes instead")
+	bodyFile := fs.String("body-file", "", "respond with this file on the server instead, within its -file-root; read when the stub is set")
+	bodyFileLive := fs.Bool("body-file-live", false, "read the body file at each request instead")
+	contentType := fs.String("content-type", "", "response content type")
+	tmpl := fs.Bool("template", false, "render the body as a Go text/template for each request")
+	priority := fs.Int("priority", 0, "priority over other stubs matching the same request")
+	headers := stringList{}
+	fs.Var(&headers, "header", `response header as "Name: value"; may be repeated`)
+	asJSON := fs.Bool("json", false, "print the stub as JSON")
+	positional := parseArgs(fs, args)
+	if len(positional) != 1 {
+		return fmt.Errorf("expected exactly one PATH")
+	}
+
+	stub := mutux.Message{
+		Response: mutux.Response{
+			Status:       status,
+			ContentType:  *contentType,
+			Template:     *tmpl,
+			BodyFile:     *bodyFile,
+			BodyFileLive: *bodyFileLive,
+		},
+		Match: mutux.Match{Method: *method, Priority: *priority},
+	}
+	set := setFlags(fs)
+	switch {
+	case *bodyFile != "":
+	case set["body-size"]:
+		stub.BodySize = bodySize
+	default:
+		b, err := readBody(*body)
+		if err != nil {
+			return err
+		}
+		if utf8.Valid(b) {
+			msg := string(b)
+			stub.Msg = &msg
+		} else {
+			stub.Bytes = b
+		}
+	}
+	for _, h := range headers {
+		parts := strings.SplitN(h, ":", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("invalid header %q, expected \"Name: value\"", h)
+		}
+		if stub.Headers == nil {
+			stub.Headers = mutux.HeaderValues{}
+		}
+		name := strings.TrimSpace(parts[0])
+		stub.Headers[name] = append(stub.Headers[name], strings.TrimSpace(parts[1]))
+	}
+	// query params in the path become exact-value conditions
+	created, err := newClient().AddStub(positional[0], stub)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return printJSON(created)
+	}
+	fmt.Printf("stub %s set on %s\n", created.ID, created.Path)
+	return nil
+}
+
+// readBody return body as given, or read from the file it names after @, or from stdin for @-
+func readBody(body string) ([]byte, error) {
+	if !strings.HasPrefix(body, "@") {
+		return []byte(body), nil
+	}
+	var b []byte
+	var err error
+	if body == "@-" {
+		b, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		b, err = ioutil.ReadFile(body[1:])
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read body: %s", err.Error())
+	}
+	return b, nil
+}
+
+func stubLsCmd(args []string) error {
+	fs := flag.NewFlagSet("stub ls", flag.ExitOnError)
+	newClient := clientFlags(fs)
+	asJSON := fs.Bool("json", false, "print stubs as JSON")
+	positional := parseArgs(fs, args)
+	if len(positional) > 1 {
+		return fmt.Errorf("expected at most one PATH")
+	}
+	c := newClient()
+	var stubs []mutux.Stub
+	var err error
+	if len(positional) == 1 {
+		stubs, err = c.StubsAt(positional[0])
+	} else {
+		stubs, err = c.Stubs(), c.Err()
+	}
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return printJSON(stubs)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tMETHOD\tPATH\tSTATUS\tBODY")
+	for _, s := range stubs {
+		method := s.Method
+		if method == "" {
+			method = "*"
+		}
+		status, body := "", ""
+		if len(s.Responses) > 0 {
+			status = fmt.Sprintf("%d responses", len(s.Responses))
+		} else {
+			if s.Status != nil {
+				status = strconv.Itoa(*s.Status)
+			}
+			switch {
+			case s.BodyFileLive:
+				body = "file " + s.BodyFile
+			case s.Msg != nil:
+				body = abbreviate(*s.Msg, 40)
+			case s.Bytes != nil:
+				body = fmt.Sprintf("%d bytes", len(s.Bytes))
+			case s.BodySize != nil:
+				body = fmt.Sprintf("%d synthetic bytes", *s.BodySize)
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", s.ID, method, s.Path, status, body)
+	}
+	return w.Flush()
+}
+
+// abbreviate quote s on a single line, cut to about n characters
+func abbreviate(s string, n int) string {
+	if len(s) > n {
+		s = s[:n] + "..."
+	}
+	return strconv.Quote(s)
+}
+
+func stubRmCmd(args []string) error {
+	fs := flag.NewFlagSet("stub rm", flag.ExitOnError)
+	newClient := clientFlags(fs)
+	id := fs.String("id", "", "delete only the stub with this id")
+	positional := parseArgs(fs, args)
+	if len(positional) != 1 {
+		return fmt.Errorf("expected exactly one PATH")
+	}
+	c := newClient()
+	stubs, err := c.StubsAt(positional[0])
+	if err != nil {
+		return err
+	}
+	if len(stubs) == 0 {
+		return fmt.Errorf("stub /%s not found", strings.TrimLeft(positional[0], "/"))
+	}
+	if *id == "" {
+		c.DelPathMsg(positional[0])
+		return c.Err()
+	}
+	for _, stub := range stubs {
+		if stub.ID == *id {
+			c.DelStub(*id)
+			return c.Err()
+		}
+	}
+	return fmt.Errorf("stub %s not found on /%s", *id, strings.TrimLeft(positional[0], "/"))
+}
+
+func journalCmd(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("expected journal tail or clear")
+	}
+	switch args[0] {
+	case "tail":
+		return journalTailCmd(args[1:])
+	case "clear":
+		fs := flag.NewFlagSet("journal clear", flag.ExitOnError)
+		newClient := clientFlags(fs)
+		parseArgs(fs, args[1:])
+		c := newClient()
+		c.ResetJournal()
+		return c.Err()
+	}
+	return fmt.Errorf("unknown command journal %s", args[0])
+}
+
+// journalTailCmd print the last requests received, and with -f keep printing new ones
+func journalTailCmd(args []string) error {
+	fs := flag.NewFlagSet("journal tail", flag.ExitOnError)
+	newClient := clientFlags(fs)
+	n := fs.Int("n", 10, "number of past requests to print")
+	follow := fs.Bool("f", false, "keep printing requests as they are received")
+	interval := fs.Duration("interval", time.Second, "how often to poll with -f")
+	method := fs.String("method", "", "only requests with this method")
+	path := fs.String("path", "", "only requests to this path")
+	asJSON := fs.Bool("json", false, "print requests as JSON, one per line")
+	parseArgs(fs, args)
+
+	c := newClient()
+	// entries are numbered as they are added, so polling since the last printed misses none
+	var last int64
+	for first := true; ; first = false {
+		entries := c.FindRequestsSince(*method, *path, last)
+		err := c.Err()
+		if err != nil {
+			return err
+		}
+		// only the last n on the first poll
+		if first && len(entries) > *n {
+			entries = entries[len(entries)-*n:]
+		}
+		for _, e := range entries {
+			printEntry(e, *asJSON)
+			last = e.Seq
+		}
+		if !*follow {
+			return nil
+		}
+		time.Sleep(*interval)
+	}
+}
+
+func printEntry(e mutux.JournalEntry, asJSON bool) {
+	if asJSON {
+		b, _ := json.Marshal(e)
+		fmt.Println(string(b))
+		return
+	}
+	target := e.Path
+	if len(e.Query) > 0 {
+		target += "?" + e.Query.Encode()
+	}
+	stub := "no stub"
+	if e.StubID != "" {
+		stub = fmt.Sprintf("stub %s", e.StubID)
+	}
+	fmt.Printf("%s %s %s %d (%s)\n", e.Time.Format(time.RFC3339), e.Method, target, e.Status, stub)
+}
+
+func resetCmd(args []string) error {
+	fs := flag.NewFlagSet("reset", flag.ExitOnError)
+	newClient := clientFlags(fs)
+	parseArgs(fs, args)
+	c := newClient()
+	c.Reset()
+	return c.Err()
+}
+
+func printJSON(v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(b))
+	return nil
+}
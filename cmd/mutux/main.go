@@ -0,0 +1,192 @@
+// Command mutux runs a standalone Mutux mock server, or drives a running one through its admin API.
+//
+// Stubs are added at runtime through the admin API or by PUT, or declared in a JSON or YAML
+// config file. If the config is a directory, every config file in it is loaded and reloaded
+// as it changes.
+//
+//	mutux -addr :8080 -config stubs.yaml
+//	mutux -mount /assets=./testdata/assets
+//	mutux stub set /hello -status 201 -body @hello.json
+//	mutux stub ls
+//	mutux stub rm /hello
+//	mutux journal tail -f
+//	mutux reset
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/dzhoou/mutux"
+)
+
+const usage = `usage:
+  mutux [serve] [flags]                 run a server
+  mutux stub set PATH [flags]           add or replace a stub
+  mutux stub ls [PATH] [flags]          list stubs
+  mutux stub rm PATH [flags]            delete stubs on a path
+  mutux journal tail [flags]            print received requests
+  mutux journal clear [flags]           clear received requests
+  mutux reset [flags]                   delete all stubs and requests, and restore default settings
+
+Run "mutux COMMAND -h" for the flags of a command.
+`
+
+func main() {
+	args := os.Args[1:]
+	cmd := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, args = args[0], args[1:]
+	}
+	var err error
+	switch cmd {
+	case "serve":
+		err = serveCmd(args)
+	case "stub":
+		err = stubCmd(args)
+	case "journal":
+		err = journalCmd(args)
+	case "reset":
+		err = resetCmd(args)
+	case "help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		err = fmt.Errorf("unknown command %q", cmd)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "mutux:", err)
+		os.Exit(1)
+	}
+}
+
+// serveCmd parse server flags and serve until interrupted
+func serveCmd(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := fs.String("addr", ":6666", "address to listen on; overrides the address of the config file")
+	certfile := fs.String("cert", "", "TLS certificate file; serves HTTPS together with -key")
+	keyfile := fs.String("key", "", "TLS key file")
+	config := fs.String("config", "", "JSON or YAML config file, or directory of config files to watch")
+	adminPrefix := fs.String("admin-prefix", mutux.AdminPrefix, "path prefix of the admin API")
+	noPUT := fs.Bool("no-put", false, "disable adding stubs by PUT to the stubbed path")
+	readOnly := fs.Bool("read-only", false, "refuse changes through the admin API and PUT to stubbed paths")
+	fileRoot := fs.String("file-root", "", "directory that stubs and mounts added through the HTTP API may read files from; none if empty")
+	logLevel := fs.String("log-level", "info", "one of debug, info, error or off")
+	mounts := stringList{}
+	fs.Var(&mounts, "mount", "directory served as PREFIX=DIR to requests no stub matches; may be repeated")
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+	return serve(*addr, *certfile, *keyfile, *config, *adminPrefix, *noPUT, *readOnly, *fileRoot, *logLevel, mounts, setFlags(fs))
+}
+
+// setFlags names of the flags given on the command line
+func setFlags(fs *flag.FlagSet) map[string]bool {
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	return set
+}
+
+// serve run the server until SIGINT or SIGTERM, then shut it down gracefully
+func serve(addr, certfile, keyfile, config, adminPrefix string, noPUT, readOnly bool, fileRoot, logLevel string, mounts []string, set map[string]bool) error {
+	level, err := mutux.ParseLogLevel(logLevel)
+	if err != nil {
+		return err
+	}
+	mutux.SetLogLevel(level)
+
+	cfg := mutux.Config{}
+	watchDir := ""
+	if config != "" {
+		info, err := os.Stat(config)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			watchDir = config
+		} else {
+			cfg, err = mutux.ReadConfig(config)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	// flags given explicitly win over the config file
+	if set["addr"] || cfg.Address == "" {
+		cfg.Address = addr
+	}
+	if set["cert"] || set["key"] {
+		cfg.Certfile = certfile
+		cfg.Keyfile = keyfile
+	}
+	for _, mount := range mounts {
+		parts := strings.SplitN(mount, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("invalid mount %q, expected PREFIX=DIR", mount)
+		}
+		cfg.Mounts = append(cfg.Mounts, mutux.Mount{Prefix: parts[0], Dir: parts[1]})
+	}
+
+	m, err := mutux.NewMutuxFromConfig(cfg)
+	if err != nil {
+		return err
+	}
+	if watchDir != "" {
+		err = m.WatchDir(watchDir, mutux.DefaultWatchInterval)
+		if err != nil {
+			m.Stop()
+			return err
+		}
+	}
+	if noPUT {
+		m.DisablePUT()
+	}
+	m.SetReadOnly(readOnly)
+	err = m.SetFileRoot(fileRoot)
+	if err != nil {
+		m.Stop()
+		return err
+	}
+	err = m.SetAdminPrefix(adminPrefix)
+	if err != nil {
+		m.Stop()
+		return err
+	}
+
+	err = m.Start()
+	if err != nil {
+		m.Stop()
+		return err
+	}
+	<-m.Ready()
+	fmt.Printf("mutux listening on %s, admin API under %s\n", m.Address, m.AdminPrefix())
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- m.Wait()
+	}()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case err = <-errc:
+		m.StopWatching()
+		return err
+	case sig := <-signals:
+		fmt.Printf("received %s, shutting down\n", sig)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), mutux.DefaultShutdownTimeout)
+	defer cancel()
+	err = m.Shutdown(ctx)
+	if err != nil {
+		return fmt.Errorf("Failed to shut down cleanly: %s", err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,397 @@
+package mutux
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Config state of a Mutux server as declared in a JSON or YAML file
+type Config struct {
+	Address  string            `json:"address,omitempty"`
+	Certfile string            `json:"certfile,omitempty"`
+	Keyfile  string            `json:"keyfile,omitempty"`
+	AllowPUT *bool             `json:"allowPUT,omitempty"`
+	Headers  map[string]string `json:"headers,omitempty"`
+	// Paths how request paths are compared with stub paths; see PathOptions
+	Paths *PathOptions `json:"paths,omitempty"`
+	// Unmatched answer to requests no stub matches; see UnmatchedResponse
+	Unmatched *UnmatchedResponse `json:"unmatched,omitempty"`
+	// Mounts directories served under path prefixes; see Mount
+	Mounts []Mount      `json:"mounts,omitempty"`
+	Stubs  []StubConfig `json:"stubs,omitempty"`
+}
+
+// StubConfig stub as declared in a config file; the path may carry query params as in AddStub
+type StubConfig struct {
+	Path string `json:"path"`
+	Message
+}
+
+// isYAML return whether file is YAML, judging by its extension; anything else is JSON
+func isYAML(file string) bool {
+	ext := strings.ToLower(filepath.Ext(file))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// ParseConfig parse config from JSON, or from YAML if yamlFormat is set
+func ParseConfig(b []byte, yamlFormat bool) (Config, error) {
+	return parseConfig(b, yamlFormat, "")
+}
+
+// parseConfig parse config, resolving relative body files against dir, and validate its stubs
+func parseConfig(b []byte, yamlFormat bool, dir string) (Config, error) {
+	cfg, err := decodeConfig(b, yamlFormat, dir)
+	if err != nil {
+		return cfg, err
+	}
+	for i, stub := range cfg.Stubs {
+		_, _, err = prepareStub(stub.Path, stub.Message)
+		if err != nil {
+			return cfg, fmt.Errorf("stub %d (%s): %s", i+1, stub.Path, err.Error())
+		}
+	}
+	if cfg.Paths != nil {
+		err = cfg.Paths.validate()
+		if err != nil {
+			return cfg, fmt.Errorf("paths: %s", err.Error())
+		}
+	}
+	if cfg.Unmatched != nil {
+		_, err = prepareUnmatched(*cfg.Unmatched)
+		if err != nil {
+			return cfg, fmt.Errorf("unmatched: %s", err.Error())
+		}
+	}
+	for i, mt := range cfg.Mounts {
+		_, err = prepareMount(mt)
+		if err != nil {
+			return cfg, fmt.Errorf("mount %d (%s): %s", i+1, mt.Prefix, err.Error())
+		}
+	}
+	return cfg, nil
+}
+
+// decodeConfig decode config without validating its stubs, resolving relative body files and mount dirs against dir
+func decodeConfig(b []byte, yamlFormat bool, dir string) (Config, error) {
+	cfg := Config{}
+	var err error
+	if yamlFormat {
+		b, err = yamlToJSON(b)
+		if err != nil {
+			return cfg, err
+		}
+	}
+	err = json.Unmarshal(b, &cfg)
+	if err != nil {
+		return cfg, err
+	}
+	for i := range cfg.Stubs {
+		msg := &cfg.Stubs[i].Message
+		msg.BodyFile = resolvePath(dir, msg.BodyFile)
+		for j := range msg.Responses {
+			msg.Responses[j].BodyFile = resolvePath(dir, msg.Responses[j].BodyFile)
+		}
+	}
+	if cfg.Unmatched != nil && cfg.Unmatched.Response != nil {
+		cfg.Unmatched.Response.BodyFile = resolvePath(dir, cfg.Unmatched.Response.BodyFile)
+	}
+	for i := range cfg.Mounts {
+		cfg.Mounts[i].Dir = resolvePath(dir, cfg.Mounts[i].Dir)
+	}
+	return cfg, nil
+}
+
+// ReadConfig read config from a JSON or YAML file
+func ReadConfig(file string) (Config, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return Config{}, err
+	}
+	cfg, err := parseConfig(b, isYAML(file), filepath.Dir(file))
+	if err != nil {
+		return cfg, fmt.Errorf("Failed to read config %s: %s", file, err.Error())
+	}
+	return cfg, nil
+}
+
+// WriteConfig write config to a JSON or YAML file, judging by its extension. Relative body files and
+// mount dirs, which ReadConfig returns relative to the working directory, are written relative to the
+// directory of file, so that reading it back finds them.
+func WriteConfig(file string, cfg Config) error {
+	cfg, err := relativeConfig(cfg, filepath.Dir(file))
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	if isYAML(file) {
+		b, err = jsonToYAML(b)
+		if err != nil {
+			return err
+		}
+	} else {
+		b = append(b, '\n')
+	}
+	return ioutil.WriteFile(file, b, 0644)
+}
+
+// resolvePath join relative file to dir
+func resolvePath(dir, file string) string {
+	if file == "" || dir == "" || filepath.IsAbs(file) {
+		return file
+	}
+	return filepath.Join(dir, file)
+}
+
+// relativePath make relative file, relative to the working directory, relative to dir instead
+func relativePath(dir, file string) (string, error) {
+	if file == "" || filepath.IsAbs(file) {
+		return file, nil
+	}
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return file, err
+	}
+	dir, err = filepath.Abs(dir)
+	if err != nil {
+		return file, err
+	}
+	return filepath.Rel(dir, abs)
+}
+
+// relativeConfig copy of cfg with its relative body files and mount dirs made relative to dir,
+// undoing what decodeConfig does
+func relativeConfig(cfg Config, dir string) (Config, error) {
+	var err error
+	stubs := make([]StubConfig, len(cfg.Stubs))
+	for i, stub := range cfg.Stubs {
+		stub.BodyFile, err = relativePath(dir, stub.BodyFile)
+		if err != nil {
+			return cfg, err
+		}
+		if len(stub.Responses) > 0 {
+			responses := make([]Response, len(stub.Responses))
+			for j, resp := range stub.Responses {
+				resp.BodyFile, err = relativePath(dir, resp.BodyFile)
+				if err != nil {
+					return cfg, err
+				}
+				responses[j] = resp
+			}
+			stub.Responses = responses
+		}
+		stubs[i] = stub
+	}
+	if len(stubs) > 0 {
+		cfg.Stubs = stubs
+	}
+	if cfg.Unmatched != nil && cfg.Unmatched.Response != nil {
+		unmatched, resp := *cfg.Unmatched, *cfg.Unmatched.Response
+		resp.BodyFile, err = relativePath(dir, resp.BodyFile)
+		if err != nil {
+			return cfg, err
+		}
+		unmatched.Response = &resp
+		cfg.Unmatched = &unmatched
+	}
+	if len(cfg.Mounts) > 0 {
+		mounts := make([]Mount, len(cfg.Mounts))
+		for i, mt := range cfg.Mounts {
+			mt.Dir, err = relativePath(dir, mt.Dir)
+			if err != nil {
+				return cfg, err
+			}
+			mounts[i] = mt
+		}
+		cfg.Mounts = mounts
+	}
+	return cfg, nil
+}
+
+// yamlToJSON convert YAML to JSON, so that config is decoded the same way from both
+func yamlToJSON(b []byte) ([]byte, error) {
+	var v interface{}
+	err := yaml.Unmarshal(b, &v)
+	if err != nil {
+		return nil, err
+	}
+	v, err = jsonCompatible(v)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+func jsonToYAML(b []byte) ([]byte, error) {
+	var v interface{}
+	err := json.Unmarshal(b, &v)
+	if err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(v)
+}
+
+// jsonCompatible turn the map[interface{}]interface{} values yaml decodes into map[string]interface{}
+func jsonCompatible(v interface{}) (interface{}, error) {
+	switch t := v.(type) {
+	case map[interface{}]interface{}:
+		m := map[string]interface{}{}
+		for k, val := range t {
+			converted, err := jsonCompatible(val)
+			if err != nil {
+				return nil, err
+			}
+			m[fmt.Sprint(k)] = converted
+		}
+		return m, nil
+	case []interface{}:
+		for i, val := range t {
+			converted, err := jsonCompatible(val)
+			if err != nil {
+				return nil, err
+			}
+			t[i] = converted
+		}
+		return t, nil
+	}
+	return v, nil
+}
+
+// NewMutuxFromConfig creates a new instance of Mutux server from config
+func NewMutuxFromConfig(cfg Config) (*Mutux, error) {
+	m, err := NewMutuxWithAddr(cfg.Address)
+	if err != nil {
+		return nil, err
+	}
+	err = m.ApplyConfig(cfg)
+	if err != nil {
+		m.Stop()
+		return nil, err
+	}
+	return m, nil
+}
+
+// NewMutuxFromFile creates a new instance of Mutux server from a JSON or YAML config file
+func NewMutuxFromFile(file string) (*Mutux, error) {
+	cfg, err := ReadConfig(file)
+	if err != nil {
+		return nil, err
+	}
+	return NewMutuxFromConfig(cfg)
+}
+
+// ApplyConfig replace stubs with those of cfg, set TLS files, headers and PUT setting where cfg has them,
+// and add its mounts. The address of cfg is ignored; nothing changes if any stub or mount is invalid.
+func (m *Mutux) ApplyConfig(cfg Config) error {
+	if m == nil {
+		return nil
+	}
+	stubs := make([]Stub, len(cfg.Stubs))
+	for i, s := range cfg.Stubs {
+		path, msg, err := prepareStub(s.Path, s.Message)
+		if err != nil {
+			return fmt.Errorf("stub %d (%s): %s", i+1, s.Path, err.Error())
+		}
+		stubs[i] = Stub{Path: path, Message: msg}
+	}
+	if cfg.Paths != nil {
+		err := cfg.Paths.validate()
+		if err != nil {
+			return fmt.Errorf("paths: %s", err.Error())
+		}
+	}
+	var unmatched UnmatchedResponse
+	if cfg.Unmatched != nil {
+		var err error
+		unmatched, err = prepareUnmatched(*cfg.Unmatched)
+		if err != nil {
+			return fmt.Errorf("unmatched: %s", err.Error())
+		}
+	}
+	mounts := make([]Mount, len(cfg.Mounts))
+	for i, mt := range cfg.Mounts {
+		var err error
+		mounts[i], err = prepareMount(mt)
+		if err != nil {
+			return fmt.Errorf("mount %d (%s): %s", i+1, mt.Prefix, err.Error())
+		}
+	}
+	if cfg.Certfile != "" || cfg.Keyfile != "" {
+		m.Certfile = cfg.Certfile
+		m.Keyfile = cfg.Keyfile
+	}
+	if cfg.Paths != nil {
+		m.store.setPaths(*cfg.Paths)
+	}
+	m.store.load(stubs, cfg.Headers, cfg.AllowPUT)
+	if cfg.Unmatched != nil {
+		m.store.setUnmatched(unmatched)
+	}
+	for _, mt := range mounts {
+		m.mounts.set(mt)
+	}
+	return nil
+}
+
+// Config return the current state as config, including stubs added at runtime, with the address the
+// server was created with rather than the port picked for port 0
+func (m *Mutux) Config() Config {
+	if m == nil {
+		return Config{}
+	}
+	allowPUT := m.store.putAllowed()
+	paths := m.store.pathOptions()
+	unmatched := m.store.unmatchedResponse()
+	if unmatched.Response != nil && unmatched.Response.BodyFile != "" {
+		unmatched.Response.Msg, unmatched.Response.Bytes = nil, nil
+	}
+	cfg := Config{
+		Address:   m.configAddr,
+		Certfile:  m.Certfile,
+		Keyfile:   m.Keyfile,
+		AllowPUT:  &allowPUT,
+		Headers:   m.store.headerCopy(),
+		Paths:     &paths,
+		Unmatched: &unmatched,
+	}
+	for _, mt := range m.mounts.all() {
+		// an fs.FS cannot be saved
+		if mt.Dir != "" {
+			cfg.Mounts = append(cfg.Mounts, mt)
+		}
+	}
+	for _, stub := range m.store.stubs() {
+		// messages read from body files are saved as the file reference only
+		msg := stub.Message
+		if msg.BodyFile != "" {
+			msg.Msg, msg.Bytes = nil, nil
+		}
+		responses := make([]Response, len(msg.Responses))
+		for i, resp := range msg.Responses {
+			if resp.BodyFile != "" {
+				resp.Msg, resp.Bytes = nil, nil
+			}
+			responses[i] = resp
+		}
+		if len(responses) > 0 {
+			msg.Responses = responses
+		}
+		cfg.Stubs = append(cfg.Stubs, StubConfig{Path: stub.Path, Message: msg})
+	}
+	return cfg
+}
+
+// SaveConfig save the current state to a JSON or YAML file, judging by its extension
+func (m *Mutux) SaveConfig(file string) error {
+	if m == nil {
+		return nil
+	}
+	return WriteConfig(file, m.Config())
+}
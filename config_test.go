@@ -0,0 +1,72 @@
+package mutux
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestSaveConfigRoundTrip check that a saved config reads back with the paths and address it declared,
+// wherever it is saved
+func TestSaveConfigRoundTrip(t *testing.T) {
+	quiet(t)
+	dir, err := ioutil.TempDir("", "mutux-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// relative config paths are where saving went wrong
+	err = os.Chdir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	err = os.MkdirAll(filepath.Join("conf", "static"), 0755)
+	if err == nil {
+		err = os.Mkdir("out", 0755)
+	}
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join("conf", "body.txt"), []byte("body"), 0644)
+	}
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join("conf", "mutux.json"), []byte(`{
+			"address": "127.0.0.1:0",
+			"mounts": [{"prefix": "/static", "dir": "static"}],
+			"stubs": [{"path": "/hello", "bodyFile": "body.txt"}]
+		}`), 0644)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := NewMutuxFromFile(filepath.Join("conf", "mutux.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop()
+	for _, file := range []string{filepath.Join("conf", "saved.json"), filepath.Join("out", "saved.yaml"), "saved.json"} {
+		err = m.SaveConfig(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := ReadConfig(file)
+		if err != nil {
+			t.Fatalf("reading back %s: %s", file, err.Error())
+		}
+		if cfg.Address != "127.0.0.1:0" {
+			t.Errorf("%s address = %s, want the configured 127.0.0.1:0", file, cfg.Address)
+		}
+		if len(cfg.Stubs) != 1 || cfg.Stubs[0].BodyFile != filepath.Join("conf", "body.txt") {
+			t.Errorf("%s stubs = %+v, want the body file conf/body.txt", file, cfg.Stubs)
+		}
+		if len(cfg.Mounts) != 1 || cfg.Mounts[0].Dir != filepath.Join("conf", "static") {
+			t.Errorf("%s mounts = %+v, want the dir conf/static", file, cfg.Mounts)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package mutux
+
+// Controller stub, header, setting and journal operations shared by an in-process Mutux and
+// a remote one driven over HTTP with the client package, so tests can be written against either.
+type Controller interface {
+	AddPathMsg(path, msg string)
+	AddPathMsgAndStatus(path, msg string, status int)
+	AddMethodPathMsg(method, path, msg string)
+	AddMethodPathMsgAndStatus(method, path, msg string, status int)
+	AddPathMsgAndHeaders(path, msg string, status int, headers map[string]string)
+	AddStub(path string, msg Message) (Stub, error)
+	DelPathMsg(path string)
+	DelMethodPathMsg(method, path string)
+	DelStub(id string) bool
+	ResetSequence(id string) bool
+	ResetSequences()
+	Stubs() []Stub
+	AddHeader(name, value string)
+	DelHeader(name string)
+	Headers() map[string]string
+	EnablePUT()
+	DisablePUT()
+	PUTAllowed() bool
+	SetPathOptions(o PathOptions) error
+	PathOptions() PathOptions
+	SetUnmatchedResponse(u UnmatchedResponse) error
+	UnmatchedResponse() UnmatchedResponse
+	Requests() []JournalEntry
+	FindRequests(method, path string) []JournalEntry
+	UnmatchedRequests() []JournalEntry
+	ResetJournal()
+	AddMount(mt Mount) error
+	MountDir(prefix, dir string) error
+	Unmount(prefix string) bool
+	Mounts() []Mount
+	ExpectOrder(ids ...string) error
+	Verify() Report
+	Reset()
+}
+
+var _ Controller = (*Mutux)(nil)
@@ -25,12 +25,11 @@ func main() {
 	fn := func(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprintf(w, "myfunc")
 	}
-	// Adds function to path /myfunc
+	// Adds function to path /myfunc; it takes effect immediately, no restart needed
 	mutuxServer.AddHandlerFunc(`/myfunc`, &fn, []string{"GET"})
-	// In order for added functions to take effect, the server has to be restarted
-	// Alternatively, you can call mutuxServer.AddHandlerFuncAndRestart() to add a function and restart.
-	mutuxServer.Restart()
 
-	// "select {}" hangs main program allowing server to run
-	select {}
+	// Wait holds main program until the server stops, allowing it to run
+	if err := mutuxServer.Wait(); err != nil {
+		fmt.Println("Mutux server stopped: " + err.Error())
+	}
 }
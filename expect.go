@@ -0,0 +1,329 @@
+package mutux
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// maxClosest number of near-miss requests listed for an expectation that was not met
+const maxClosest = 3
+
+// Expect number of requests a stub is expected to answer, checked by Verify.
+// Times is exact; otherwise AtLeast and AtMost bound the number, and either may be left unset.
+type Expect struct {
+	Times   *int `json:"times,omitempty"`
+	AtLeast *int `json:"atLeast,omitempty"`
+	AtMost  *int `json:"atMost,omitempty"`
+}
+
+// ExpectTimes expect exactly n requests
+func ExpectTimes(n int) *Expect {
+	return &Expect{Times: &n}
+}
+
+// ExpectAtLeast expect n requests or more
+func ExpectAtLeast(n int) *Expect {
+	return &Expect{AtLeast: &n}
+}
+
+// ExpectAtMost expect n requests or fewer
+func ExpectAtMost(n int) *Expect {
+	return &Expect{AtMost: &n}
+}
+
+// ExpectNever expect no request
+func ExpectNever() *Expect {
+	return ExpectTimes(0)
+}
+
+func (e *Expect) validate() error {
+	if e == nil {
+		return nil
+	}
+	for _, n := range []*int{e.Times, e.AtLeast, e.AtMost} {
+		if n != nil && *n < 0 {
+			return fmt.Errorf("expected number of requests must not be negative")
+		}
+	}
+	if e.Times != nil && (e.AtLeast != nil || e.AtMost != nil) {
+		return fmt.Errorf("expect either times, or atLeast and atMost")
+	}
+	if e.AtLeast != nil && e.AtMost != nil && *e.AtMost < *e.AtLeast {
+		return fmt.Errorf("expected atMost must not be less than atLeast")
+	}
+	return nil
+}
+
+// met return whether calls requests satisfy the expectation
+func (e *Expect) met(calls int) bool {
+	if e.Times != nil {
+		return calls == *e.Times
+	}
+	return (e.AtLeast == nil || calls >= *e.AtLeast) && (e.AtMost == nil || calls <= *e.AtMost)
+}
+
+// tooFew return whether more requests would help meet the expectation
+func (e *Expect) tooFew(calls int) bool {
+	if e.Times != nil {
+		return calls < *e.Times
+	}
+	return e.AtLeast != nil && calls < *e.AtLeast
+}
+
+// String describe the expectation, e.g. "exactly 2 requests"
+func (e *Expect) String() string {
+	switch {
+	case e.Times != nil && *e.Times == 0:
+		return "no request"
+	case e.Times != nil:
+		return "exactly " + countRequests(*e.Times)
+	case e.AtLeast != nil && e.AtMost != nil:
+		return fmt.Sprintf("between %d and %s", *e.AtLeast, countRequests(*e.AtMost))
+	case e.AtLeast != nil:
+		return "at least " + countRequests(*e.AtLeast)
+	case e.AtMost != nil:
+		return "at most " + countRequests(*e.AtMost)
+	}
+	return "any number of requests"
+}
+
+// Report result of Verify; it lists the expectations that were not met
+type Report struct {
+	Failures []Failure `json:"failures"`
+}
+
+// Failure expectation that was not met, with the requests that bear on it
+type Failure struct {
+	// StubID stub the expectation is declared on; empty for an order across stubs
+	StubID string `json:"stubId,omitempty"`
+	Path   string `json:"path,omitempty"`
+	Method string `json:"method,omitempty"`
+	// Expected what was expected, e.g. "exactly 2 requests"
+	Expected string `json:"expected"`
+	// Actual what happened, e.g. "1 request"
+	Actual string `json:"actual"`
+	// Calls requests the stub answered that are still in the journal, or for an order, the requests
+	// to the ordered stubs as received
+	Calls []JournalEntry `json:"calls,omitempty"`
+	// Closest requests the stub did not answer that came closest to matching it, most similar first,
+	// each with the reasons it did not match
+	Closest []NearMiss `json:"closest,omitempty"`
+}
+
+// NearMiss request that did not match a stub, and why
+type NearMiss struct {
+	Request JournalEntry `json:"request"`
+	Reasons []string     `json:"reasons"`
+}
+
+// OK return whether all expectations were met
+func (r Report) OK() bool {
+	return len(r.Failures) == 0
+}
+
+// String describe the failures, one paragraph each
+func (r Report) String() string {
+	if r.OK() {
+		return "all expectations met"
+	}
+	b := strings.Builder{}
+	for i, f := range r.Failures {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		if f.StubID != "" {
+			method := f.Method
+			if method == "" {
+				method = "any method"
+			}
+			fmt.Fprintf(&b, "stub %s (%s %s): expected %s, got %s\n", f.StubID, method, f.Path, f.Expected, f.Actual)
+		} else {
+			fmt.Fprintf(&b, "expected %s, got %s\n", f.Expected, f.Actual)
+		}
+		for _, e := range f.Calls {
+			fmt.Fprintf(&b, "\treceived %s\n", describeEntry(e))
+		}
+		for _, miss := range f.Closest {
+			fmt.Fprintf(&b, "\tclosest %s: %s\n", describeEntry(miss.Request), strings.Join(miss.Reasons, "; "))
+		}
+		if len(f.Calls) == 0 && len(f.Closest) == 0 {
+			b.WriteString("\tno similar request received\n")
+		}
+	}
+	return b.String()
+}
+
+func describeEntry(e JournalEntry) string {
+	target := e.Path
+	if len(e.Query) > 0 {
+		target += "?" + e.Query.Encode()
+	}
+	s := fmt.Sprintf("%s %s -> %d", e.Method, target, e.Status)
+	if e.StubID != "" {
+		s += " (stub " + e.StubID + ")"
+	}
+	return s
+}
+
+func countRequests(n int) string {
+	if n == 1 {
+		return "1 request"
+	}
+	return fmt.Sprintf("%d requests", n)
+}
+
+// verify check the expectations of stubs against calls, the number of requests each answered, and
+// the orders across them against the journal entries; near misses are found comparing paths under paths
+func verify(stubs []Stub, orders [][]string, calls map[string]int, entries []JournalEntry, paths PathOptions) Report {
+	report := Report{Failures: []Failure{}}
+	byID := map[string]Stub{}
+	for _, stub := range stubs {
+		byID[stub.ID] = stub
+		if stub.Expect == nil {
+			continue
+		}
+		n := calls[stub.ID]
+		if stub.Expect.met(n) {
+			continue
+		}
+		f := Failure{
+			StubID:   stub.ID,
+			Path:     stub.Path,
+			Method:   stub.Method,
+			Expected: stub.Expect.String(),
+			Actual:   countRequests(n),
+			Calls:    []JournalEntry{},
+		}
+		for _, e := range entries {
+			if e.StubID == stub.ID {
+				f.Calls = append(f.Calls, e)
+			}
+		}
+		if stub.Expect.tooFew(n) {
+			f.Closest = closestMisses(stub, entries, paths)
+		}
+		report.Failures = append(report.Failures, f)
+	}
+	for _, order := range orders {
+		if f, ok := verifyOrder(order, byID, entries); !ok {
+			report.Failures = append(report.Failures, f)
+		}
+	}
+	return report
+}
+
+// verifyOrder check that each stub in order was first called after the one before it
+func verifyOrder(order []string, byID map[string]Stub, entries []JournalEntry) (Failure, bool) {
+	position := map[string]int{}
+	for i, id := range order {
+		position[id] = i
+	}
+	calls := []JournalEntry{}
+	firstCalls := []string{}
+	called := map[string]bool{}
+	for _, e := range entries {
+		if _, ok := position[e.StubID]; ok {
+			calls = append(calls, e)
+			if !called[e.StubID] {
+				called[e.StubID] = true
+				firstCalls = append(firstCalls, e.StubID)
+			}
+		}
+	}
+	ok := len(firstCalls) == len(order)
+	for i, id := range firstCalls {
+		ok = ok && position[id] == i
+	}
+	if ok {
+		return Failure{}, true
+	}
+	describe := func(ids []string) string {
+		names := make([]string, len(ids))
+		for i, id := range ids {
+			if stub, ok := byID[id]; ok {
+				names[i] = fmt.Sprintf("stub %s (%s)", id, stub.Path)
+			} else {
+				names[i] = fmt.Sprintf("stub %s (deleted)", id)
+			}
+		}
+		return strings.Join(names, ", ")
+	}
+	actual := "no calls"
+	if len(firstCalls) > 0 {
+		actual = "first calls to " + describe(firstCalls)
+	}
+	return Failure{
+		Expected: "first calls in order " + describe(order),
+		Actual:   actual,
+		Calls:    calls,
+	}, false
+}
+
+// closestMisses requests stub did not answer that came closest to matching it, most similar first;
+// requests on unrelated paths are left out
+func closestMisses(stub Stub, entries []JournalEntry, paths PathOptions) []NearMiss {
+	type scored struct {
+		miss  NearMiss
+		score int
+	}
+	candidates := []scored{}
+	for _, e := range entries {
+		if e.StubID == stub.ID {
+			continue
+		}
+		score, reasons := compare(stub, strings.TrimLeft(e.Path, "/"), entryRequest(e), paths)
+		if score < pathWeight/2 {
+			continue
+		}
+		if len(reasons) == 0 {
+			switch {
+			case e.StubID != "":
+				reasons = []string{"answered by stub " + e.StubID + " instead"}
+			case e.Unmatched:
+				reasons = []string{"received before the stub was added"}
+			case e.Mount != "":
+				reasons = []string{"served from mount " + e.Mount + " instead"}
+			default:
+				reasons = []string{"answered by a handler func instead"}
+			}
+		}
+		candidates = append(candidates, scored{NearMiss{Request: e, Reasons: reasons}, score})
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].score > candidates[j].score
+	})
+	misses := []NearMiss{}
+	for i := 0; i < len(candidates) && i < maxClosest; i++ {
+		misses = append(misses, candidates[i].miss)
+	}
+	return misses
+}
+
+// ExpectOrder expect the stubs with ids to be first called in that order, checked by Verify
+func (m *Mutux) ExpectOrder(ids ...string) error {
+	if m == nil {
+		return nil
+	}
+	return m.store.addOrder(ids)
+}
+
+// StubCalls number of requests the stub with id answered since it was added, counted apart from the journal
+// as Verify counts them
+func (m *Mutux) StubCalls(id string) int {
+	if m == nil {
+		return 0
+	}
+	return m.store.callCount(id)
+}
+
+// Verify check the expectations declared on stubs against the number of requests each answered,
+// counted apart from the journal so that its size and ResetJournal do not change it, and the orders
+// declared with ExpectOrder against the requests in the journal
+func (m *Mutux) Verify() Report {
+	if m == nil {
+		return Report{}
+	}
+	stubs, orders, calls := m.store.expectations()
+	return verify(stubs, orders, calls, m.journal.find("", "", PathOptions{}), m.store.pathOptions())
+}
@@ -0,0 +1,74 @@
+package mutux
+
+import (
+	"net/http"
+	"testing"
+)
+
+// TestVerifyCountsPastJournal check that calls are counted beyond the journal size and across ResetJournal
+func TestVerifyCountsPastJournal(t *testing.T) {
+	m := newTestServer(t)
+	m.SetJournalSize(3)
+	ok := "ok"
+	five, err := m.AddStub("five", Message{Response: Response{Msg: &ok}, Expect: ExpectTimes(5)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.AddStub("never", Message{Response: Response{Msg: &ok}, Expect: ExpectNever()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(path string) {
+		resp, err := http.Get(m.URL() + "/" + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	for i := 0; i < 4; i++ {
+		get("five")
+	}
+	m.ResetJournal()
+	get("five")
+	if report := m.Verify(); !report.OK() {
+		t.Errorf("Verify() = %s, want all expectations met", report)
+	}
+
+	get("five")
+	report := m.Verify()
+	if len(report.Failures) != 1 || report.Failures[0].StubID != five.ID || report.Failures[0].Actual != "6 requests" {
+		t.Fatalf("Verify() = %s, want stub %s with 6 requests", report, five.ID)
+	}
+	if len(report.Failures[0].Calls) != 2 {
+		t.Errorf("%d calls listed, want the 2 in the journal", len(report.Failures[0].Calls))
+	}
+
+	m.Reset()
+	m.AddStub("five", Message{Response: Response{Msg: &ok}, Expect: ExpectNever()})
+	if report := m.Verify(); !report.OK() {
+		t.Errorf("Verify() after Reset = %s, want all expectations met", report)
+	}
+}
+
+// TestVerifyReplacedStub check that a stub replaced in place counts its calls afresh
+func TestVerifyReplacedStub(t *testing.T) {
+	m := newTestServer(t)
+	ok := "ok"
+	once, err := m.AddStub("once", Message{Response: Response{Msg: &ok}, Expect: ExpectTimes(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	get(t, m, "/once")
+	replaced, err := m.AddStub("once", Message{Response: Response{Msg: &ok}, Expect: ExpectTimes(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replaced.ID != once.ID {
+		t.Fatalf("replaced stub has id %s, want %s", replaced.ID, once.ID)
+	}
+	get(t, m, "/once")
+	if report := m.Verify(); !report.OK() {
+		t.Errorf("Verify() = %s, want the replaced stub called once", report)
+	}
+}
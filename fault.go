@@ -0,0 +1,226 @@
+package mutux
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Fault types injected instead of a stub's response
+const (
+	// FaultDrop send status, headers and part of the body, then close the connection
+	FaultDrop = "drop"
+	// FaultHang never respond; the request is held until the client gives up
+	FaultHang = "hang"
+	// FaultMalformed send bytes that are not valid HTTP, then close the connection
+	FaultMalformed = "malformed"
+)
+
+// Delay latency added before a stub responds, in milliseconds. The parts add up:
+// Fixed, plus a uniformly distributed delay between Min and Max, or of Min if Max is not set,
+// plus a log-normally distributed delay with Median and Sigma if Median is set.
+type Delay struct {
+	Fixed  int     `json:"fixed,omitempty"`
+	Min    int     `json:"min,omitempty"`
+	Max    int     `json:"max,omitempty"`
+	Median int     `json:"median,omitempty"`
+	Sigma  float64 `json:"sigma,omitempty"`
+}
+
+// Fault failures a stub injects instead of responding normally. Each is injected with its probability,
+// or always if that is 0. Drop and malformed faults take over the connection, so over TLS the server
+// speaks HTTP/1.1 unless Server.TLSNextProto is set.
+type Fault struct {
+	// ErrorRate probability, between 0 and 1, of answering ErrorStatus instead of the response;
+	// errors are injected if either is set
+	ErrorRate float64 `json:"errorRate,omitempty"`
+	// ErrorStatus status of injected errors; defaults to 500
+	ErrorStatus int `json:"errorStatus,omitempty"`
+	// Type FaultDrop, FaultHang or FaultMalformed, injected with Probability
+	Type string `json:"type,omitempty"`
+	// Probability, between 0 and 1, that Type is injected; needs Type
+	Probability float64 `json:"probability,omitempty"`
+}
+
+func (d *Delay) validate() error {
+	if d == nil {
+		return nil
+	}
+	if d.Fixed < 0 || d.Min < 0 || d.Max < 0 || d.Median < 0 || d.Sigma < 0 {
+		return fmt.Errorf("delay must not be negative")
+	}
+	if d.Max > 0 && d.Max < d.Min {
+		return fmt.Errorf("delay max must not be less than min")
+	}
+	return nil
+}
+
+func (f *Fault) validate() error {
+	if f == nil {
+		return nil
+	}
+	if f.ErrorRate < 0 || f.ErrorRate > 1 || f.Probability < 0 || f.Probability > 1 {
+		return fmt.Errorf("fault probabilities must be between 0 and 1")
+	}
+	switch f.Type {
+	case "", FaultDrop, FaultHang, FaultMalformed:
+	default:
+		return fmt.Errorf("unknown fault type %q", f.Type)
+	}
+	if f.Probability > 0 && f.Type == "" {
+		return fmt.Errorf("fault probability needs a fault type")
+	}
+	return nil
+}
+
+// errors return whether f injects error statuses
+func (f *Fault) errors() bool {
+	return f.ErrorRate > 0 || f.ErrorStatus != 0
+}
+
+// faultRand synchronized random source for delays and faults, seedable for reproducible runs
+type faultRand struct {
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+func newFaultRand() *faultRand {
+	return &faultRand{rng: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+func (f *faultRand) seed(seed int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rng = rand.New(rand.NewSource(seed))
+}
+
+func (f *faultRand) float64() float64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.rng.Float64()
+}
+
+// intn random integer in [0, n)
+func (f *faultRand) intn(n int) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.rng.Intn(n)
+}
+
+func (f *faultRand) normFloat64() float64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.rng.NormFloat64()
+}
+
+// duration draw a delay
+func (f *faultRand) duration(d *Delay) time.Duration {
+	if d == nil {
+		return 0
+	}
+	ms := float64(d.Fixed)
+	if d.Max > 0 {
+		ms += float64(d.Min) + f.float64()*float64(d.Max-d.Min)
+	} else {
+		ms += float64(d.Min)
+	}
+	if d.Median > 0 {
+		ms += float64(d.Median) * math.Exp(d.Sigma*f.normFloat64())
+	}
+	return time.Duration(ms * float64(time.Millisecond))
+}
+
+// chance return true with probability p, or always if p is 0
+func (f *faultRand) chance(p float64) bool {
+	if p == 0 {
+		return true
+	}
+	return f.float64() < p
+}
+
+// wait sleep for the stub's delay, returning false if the client gave up meanwhile
+func (f *faultRand) wait(r *http.Request, d *Delay) bool {
+	delay := f.duration(d)
+	if delay <= 0 {
+		return true
+	}
+	select {
+	case <-time.After(delay):
+		return true
+	case <-r.Context().Done():
+		return false
+	}
+}
+
+// inject apply fault to the request, returning true if it replaced the response, sent with the global
+// headers; hanging requests are released once closing is closed
+func (f *faultRand) inject(w http.ResponseWriter, r *http.Request, fault *Fault, resp Response, headers map[string]string, closing <-chan struct{}) bool {
+	if fault == nil {
+		return false
+	}
+	if fault.errors() && f.chance(fault.ErrorRate) {
+		status := fault.ErrorStatus
+		if status == 0 {
+			status = http.StatusInternalServerError
+		}
+		writeError(w, status, "injected fault")
+		return true
+	}
+	if fault.Type == "" || !f.chance(fault.Probability) {
+		return false
+	}
+	logf(LogInfo, "\nInjecting %s fault for %s %s\n", fault.Type, r.Method, r.URL.Path)
+	hijacker, ok := w.(http.Hijacker)
+	if fault.Type == FaultHang {
+		// held until the client gives up, or the server shuts down and drops the connection
+		select {
+		case <-r.Context().Done():
+		case <-closing:
+			if ok {
+				if conn, _, err := hijacker.Hijack(); err == nil {
+					conn.Close()
+				}
+			}
+		}
+		return true
+	}
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "connection cannot be hijacked to inject fault")
+		return true
+	}
+	conn, buf, err := hijacker.Hijack()
+	if err != nil {
+		return true
+	}
+	defer conn.Close()
+	if fault.Type == FaultMalformed {
+		buf.WriteString("HTTP/1.1 ???\r\n\x00\x7f garbage: \r\n\r\n")
+	} else {
+		body, size, err := openBody(resp)
+		if err != nil {
+			return true
+		}
+		defer body.Close()
+		h := responseHeader(resp, headers)
+		h.Set("Content-Length", strconv.FormatInt(size, 10))
+		buf.WriteString("HTTP/1.1 " + strconv.Itoa(*resp.Status) + " " + http.StatusText(*resp.Status) + "\r\n")
+		h.Write(buf)
+		buf.WriteString("\r\n")
+		io.CopyN(buf, body, size/2)
+	}
+	buf.Flush()
+	return true
+}
+
+// SetSeed seed the random source of delays, faults and template helpers, making them reproducible
+func (m *Mutux) SetSeed(seed int64) {
+	if m == nil {
+		return
+	}
+	m.faults.seed(seed)
+}
@@ -0,0 +1,96 @@
+package mutux
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDropFaultHeaders(t *testing.T) {
+	m := newTestServer(t)
+	m.AddHeader("X-Global", "g")
+	msg := "0123456789"
+	_, err := m.AddStub("/drop", Message{
+		Response: Response{Msg: &msg, ContentType: "text/plain", Headers: HeaderValues{"X-Stub": {"s"}}},
+		Fault:    &Fault{Type: FaultDrop},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.Dial("tcp", m.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("GET /drop HTTP/1.1\r\nHost: x\r\n\r\n"))
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, want := range map[string]string{"X-Global": "g", "X-Stub": "s", "Content-Type": "text/plain", "Content-Length": "10"} {
+		if got := resp.Header.Get(k); got != want {
+			t.Errorf("header %s = %q, want %q", k, got, want)
+		}
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	if err == nil || !strings.HasPrefix(msg, string(b)) || len(b) != 5 {
+		t.Errorf("body %q, %v; want truncated to 5 bytes", b, err)
+	}
+}
+
+func TestDelayDuration(t *testing.T) {
+	f := newFaultRand()
+	f.seed(1)
+	for _, c := range []struct {
+		delay    Delay
+		min, max int
+	}{
+		{Delay{Fixed: 20}, 20, 20},
+		{Delay{Min: 30}, 30, 30},
+		{Delay{Fixed: 10, Min: 30}, 40, 40},
+		{Delay{Min: 10, Max: 20}, 10, 20},
+		{Delay{Max: 5}, 0, 5},
+	} {
+		for i := 0; i < 20; i++ {
+			d := f.duration(&c.delay)
+			if d < time.Duration(c.min)*time.Millisecond || d > time.Duration(c.max)*time.Millisecond {
+				t.Errorf("delay %+v drew %s, want between %dms and %dms", c.delay, d, c.min, c.max)
+				break
+			}
+		}
+	}
+}
+
+func TestFaultRates(t *testing.T) {
+	m := newTestServer(t)
+	m.SetSeed(1)
+	for _, c := range []struct {
+		name   string
+		fault  Fault
+		status int
+	}{
+		{"error status without rate", Fault{ErrorStatus: http.StatusServiceUnavailable}, http.StatusServiceUnavailable},
+		{"certain error rate", Fault{ErrorRate: 1}, http.StatusInternalServerError},
+		{"no errors", Fault{Type: FaultHang, Probability: 0.000001}, http.StatusOK},
+	} {
+		msg := "ok"
+		_, err := m.AddStub("rate", Message{Response: Response{Msg: &msg}, Fault: &c.fault})
+		if err != nil {
+			t.Fatalf("%s: %s", c.name, err.Error())
+		}
+		if status, _ := get(t, m, "/rate"); status != c.status {
+			t.Errorf("%s: answered %d, want %d", c.name, status, c.status)
+		}
+	}
+
+	for _, fault := range []Fault{{Probability: 0.5}, {ErrorRate: 2}, {Type: "flaky"}} {
+		msg := "ok"
+		if _, err := m.AddStub("bad", Message{Response: Response{Msg: &msg}, Fault: &fault}); err == nil {
+			t.Errorf("AddStub with fault %+v succeeded", fault)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package mutux
+
+import (
+	"io/ioutil"
+	"net/http"
+	"testing"
+)
+
+// get return the status and body of GET path on m
+func get(t *testing.T, m *Mutux, path string) (int, string) {
+	t.Helper()
+	resp, err := http.Get(m.URL() + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, string(b)
+}
+
+// TestHandlerFuncHotSwap check that handler funcs take effect without restart, and direct edits of
+// CustomHandlerfuncs on Restart
+func TestHandlerFuncHotSwap(t *testing.T) {
+	m := newTestServer(t)
+	m.AddPathMsg("stub", "stub")
+	hello := func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	}
+	other := func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("other"))
+	}
+
+	m.AddHandlerFunc("/hello", &hello, nil)
+	if status, body := get(t, m, "/hello"); status != http.StatusOK || body != "hello" {
+		t.Errorf("GET /hello after AddHandlerFunc = %d %q, want 200 \"hello\"", status, body)
+	}
+	// the latest func added on a route wins, and shadows stubs
+	m.AddHandlerFunc("/hello", &other, nil)
+	m.AddHandlerFunc("/stub", &hello, []string{"POST"})
+	if status, body := get(t, m, "/hello"); status != http.StatusOK || body != "other" {
+		t.Errorf("GET /hello after second AddHandlerFunc = %d %q, want 200 \"other\"", status, body)
+	}
+	if _, body := get(t, m, "/stub"); body != "stub" {
+		t.Errorf("GET /stub = %q, want the stub past a POST handler func", body)
+	}
+
+	m.DelHandlerFunc("/hello")
+	if status, _ := get(t, m, "/hello"); status != http.StatusNotFound {
+		t.Errorf("GET /hello after DelHandlerFunc = %d, want 404", status)
+	}
+	m.ClearHandlerFunc()
+	if len(m.CustomHandlerfuncs) != 0 {
+		t.Errorf("%d handler funcs after ClearHandlerFunc", len(m.CustomHandlerfuncs))
+	}
+
+	m.CustomHandlerfuncs = append(m.CustomHandlerfuncs, Handlerfunc{Route: "/direct", Function: &hello})
+	err := m.Restart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-m.Ready()
+	if status, body := get(t, m, "/direct"); status != http.StatusOK || body != "hello" {
+		t.Errorf("GET /direct after Restart = %d %q, want 200 \"hello\"", status, body)
+	}
+}
+
+// TestPUTStub check that a stub added for PUT answers PUT requests while PUT is allowed,
+// instead of being replaced by them
+func TestPUTStub(t *testing.T) {
+	m := newTestServer(t)
+	m.EnablePUT()
+	m.AddMethodPathMsg(http.MethodPut, "put", "put stub")
+
+	for _, body := range []string{"", `{"message":"replaced"}`} {
+		if status, got := put(t, m, "/put", body); status != http.StatusOK || got != "put stub" {
+			t.Errorf("PUT /put with body %q = %d %q, want 200 \"put stub\"", body, status, got)
+		}
+	}
+	if stubs := m.store.stubsAt("put"); len(stubs) != 1 || *stubs[0].Msg != "put stub" {
+		t.Errorf("stubs at /put = %v, want the PUT stub only", stubs)
+	}
+	if status, got := put(t, m, "/other", `{"message":"added"}`); status != http.StatusOK || got != "success" {
+		t.Errorf("PUT /other = %d %q, want the stub added", status, got)
+	}
+	if _, got := get(t, m, "/other"); got != "added" {
+		t.Errorf("GET /other = %q, want \"added\"", got)
+	}
+}
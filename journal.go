@@ -0,0 +1,288 @@
+package mutux
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultJournalSize number of requests kept in the journal unless changed with SetJournalSize
+const DefaultJournalSize = 1000
+
+// JournalEntry record of a request received by Mutux, and how it was answered
+type JournalEntry struct {
+	// Seq number of the entry, increasing in the order entries are added, as their responses complete
+	Seq     int64       `json:"seq"`
+	Method  string      `json:"method"`
+	Path    string      `json:"path"`
+	Query   url.Values  `json:"query"`
+	Headers http.Header `json:"headers"`
+	Body    string      `json:"body"`
+	Time    time.Time   `json:"time"`
+	Stub    string      `json:"stub"`
+	StubID  string      `json:"stubId,omitempty"`
+	Status  int         `json:"status"`
+	// Mount prefix of the mount that served the request, if one did
+	Mount string `json:"mount,omitempty"`
+	// Unmatched set if no stub, handler func or mount answered the request
+	Unmatched bool `json:"unmatched,omitempty"`
+	// Closest stubs that came closest to matching an unmatched request, and why they did not
+	Closest []StubNearMiss `json:"closest,omitempty"`
+}
+
+// journal bounded, synchronized record of received requests; oldest entries are dropped first.
+// Unmatched requests are also kept apart, so that plenty of matched ones do not push them out.
+type journal struct {
+	mu        sync.RWMutex
+	size      int
+	entries   []JournalEntry
+	unmatched []JournalEntry
+	// seq number of the last entry added; it keeps increasing across resets
+	seq int64
+	// prefix admin prefix, requests under which are not recorded
+	prefix string
+}
+
+type journalKey struct{}
+
+func newJournal() *journal {
+	return &journal{size: DefaultJournalSize, prefix: AdminPrefix}
+}
+
+func (j *journal) add(e JournalEntry) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.seq++
+	e.Seq = j.seq
+	j.entries = append(j.entries, e)
+	if e.Unmatched {
+		j.unmatched = append(j.unmatched, e)
+	}
+	j.trim()
+}
+
+// trim drop the oldest entries over size; caller must hold mu
+func (j *journal) trim() {
+	if over := len(j.entries) - j.size; over > 0 {
+		j.entries = append([]JournalEntry(nil), j.entries[over:]...)
+	}
+	if over := len(j.unmatched) - j.size; over > 0 {
+		j.unmatched = append([]JournalEntry(nil), j.unmatched[over:]...)
+	}
+}
+
+// findUnmatched return a copy of the unmatched entries
+func (j *journal) findUnmatched() []JournalEntry {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	return append([]JournalEntry{}, j.unmatched...)
+}
+
+// find return a copy of entries matching method and path, compared under paths; empty method or path match any
+func (j *journal) find(method, path string, paths PathOptions) []JournalEntry {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	found := []JournalEntry{}
+	path = trimPath(path)
+	for _, e := range j.entries {
+		if method != "" && !strings.EqualFold(e.Method, method) {
+			continue
+		}
+		if path != "" && !paths.same(strings.TrimLeft(e.Path, "/"), path) {
+			continue
+		}
+		found = append(found, e)
+	}
+	return found
+}
+
+// entriesSince entries numbered after seq
+func entriesSince(entries []JournalEntry, seq int64) []JournalEntry {
+	found := []JournalEntry{}
+	for _, e := range entries {
+		if e.Seq > seq {
+			found = append(found, e)
+		}
+	}
+	return found
+}
+
+func (j *journal) reset() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.entries = nil
+	j.unmatched = nil
+}
+
+func (j *journal) setSize(size int) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if size < 1 {
+		size = 1
+	}
+	j.size = size
+	j.trim()
+}
+
+func (j *journal) setPrefix(prefix string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.prefix = prefix
+}
+
+func (j *journal) isAdmin(path string) bool {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	return strings.HasPrefix(path, j.prefix+"/")
+}
+
+// record wrap next so that every request outside the admin prefix is added to the journal
+func (j *journal) record(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if j.isAdmin(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		entry := &JournalEntry{
+			Method:  r.Method,
+			Path:    r.URL.Path,
+			Query:   r.URL.Query(),
+			Headers: r.Header,
+			Body:    string(body),
+			Time:    time.Now(),
+		}
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), journalKey{}, entry)))
+		entry.Status = rec.status
+		switch {
+		case entry.Status == -1 || (entry.Status == 0 && r.Context().Err() != nil):
+			// connection hijacked, or client gave up before anything was written
+			entry.Status = 0
+		case entry.Status == 0:
+			entry.Status = http.StatusOK
+		}
+		j.add(*entry)
+	})
+}
+
+// setMatchedStub mark the journal entry of r as answered by stub
+func setMatchedStub(r *http.Request, stub Stub) {
+	if entry, ok := r.Context().Value(journalKey{}).(*JournalEntry); ok {
+		entry.Stub = stub.Path
+		entry.StubID = stub.ID
+	}
+}
+
+// setMount mark the journal entry of r as served by the mount on prefix
+func setMount(r *http.Request, prefix string) {
+	if entry, ok := r.Context().Value(journalKey{}).(*JournalEntry); ok {
+		entry.Mount = prefix
+	}
+}
+
+// setUnmatched mark the journal entry of r as answered by neither stub nor handler func,
+// with the stubs that came closest
+func setUnmatched(r *http.Request, closest []StubNearMiss) {
+	if entry, ok := r.Context().Value(journalKey{}).(*JournalEntry); ok {
+		entry.Unmatched = true
+		entry.Closest = closest
+	}
+}
+
+// statusRecorder ResponseWriter that remembers the status code written
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+// Hijack let handlers take over the connection; the journal then records status 0
+func (s *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := s.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer cannot be hijacked")
+	}
+	s.status = -1
+	return hijacker.Hijack()
+}
+
+// Flush send buffered data to the client
+func (s *statusRecorder) Flush() {
+	if flusher, ok := s.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+// Requests return a copy of all requests in the journal, oldest first
+func (m *Mutux) Requests() []JournalEntry {
+	if m == nil {
+		return nil
+	}
+	return m.journal.find("", "", PathOptions{})
+}
+
+// FindRequests return requests in the journal with given method and path; empty method or path match any.
+// Paths are compared as stub paths are, under PathOptions.
+func (m *Mutux) FindRequests(method, path string) []JournalEntry {
+	if m == nil {
+		return nil
+	}
+	return m.journal.find(method, path, m.store.pathOptions())
+}
+
+// FindRequestsSince return requests in the journal with given method and path, numbered after seq;
+// polling with the Seq of the last entry seen returns each request once
+func (m *Mutux) FindRequestsSince(method, path string, seq int64) []JournalEntry {
+	if m == nil {
+		return nil
+	}
+	return entriesSince(m.FindRequests(method, path), seq)
+}
+
+// UnmatchedRequests return requests that no stub or handler func answered, oldest first, each with
+// the stubs that came closest. They are kept apart from the journal, up to its size, until ResetJournal.
+func (m *Mutux) UnmatchedRequests() []JournalEntry {
+	if m == nil {
+		return nil
+	}
+	return m.journal.findUnmatched()
+}
+
+// ResetJournal delete all requests from the journal
+func (m *Mutux) ResetJournal() {
+	if m == nil {
+		return
+	}
+	m.journal.reset()
+}
+
+// SetJournalSize set the maximum number of requests kept in the journal
+func (m *Mutux) SetJournalSize(size int) {
+	if m == nil {
+		return
+	}
+	m.journal.setSize(size)
+}
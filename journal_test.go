@@ -0,0 +1,60 @@
+package mutux
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRequestsSince(t *testing.T) {
+	m := newTestServer(t)
+	msg := "ok"
+	m.AddStub("/slow", Message{Response: Response{Msg: &msg}, Delay: &Delay{Fixed: 200}})
+	m.AddStub("/fast", Message{Response: Response{Msg: &msg}})
+
+	since := func(seq int64) []JournalEntry {
+		resp, err := http.Get(fmt.Sprintf("%s%s/requests?since=%d", m.URL(), AdminPrefix, seq))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		entries := []JournalEntry{}
+		err = json.NewDecoder(resp.Body).Decode(&entries)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return entries
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		resp, err := http.Get(m.URL() + "/slow")
+		if err == nil {
+			resp.Body.Close()
+		}
+	}()
+	time.Sleep(50 * time.Millisecond)
+	resp, err := http.Get(m.URL() + "/fast")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	entries := since(0)
+	if len(entries) != 1 || entries[0].Path != "/fast" {
+		t.Fatalf("since 0 = %v, want /fast only", entries)
+	}
+	<-done
+	// the slow request arrived first, but is numbered after the fast one, so polling finds it
+	entries = since(entries[0].Seq)
+	if len(entries) != 1 || entries[0].Path != "/slow" {
+		t.Fatalf("since /fast = %v, want /slow only", entries)
+	}
+	resp, _ = http.Get(fmt.Sprintf("%s%s/requests?since=x", m.URL(), AdminPrefix))
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("since=x answered %d, want 400", resp.StatusCode)
+	}
+}
@@ -0,0 +1,120 @@
+package mutux
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// jsonPath compiled subset of JSONPath: $ followed by .name, ['name'], [n], [*] or .* steps
+type jsonPath []jsonStep
+
+// jsonStep single step; name is used for object members, index for array elements
+type jsonStep struct {
+	name     string
+	index    int
+	isIndex  bool
+	wildcard bool
+}
+
+func parseJSONPath(expr string) (jsonPath, error) {
+	invalid := func(reason string) error {
+		return fmt.Errorf("invalid JSONPath %s: %s", expr, reason)
+	}
+	if !strings.HasPrefix(expr, "$") {
+		return nil, invalid("must start with $")
+	}
+	path := jsonPath{}
+	rest := expr[1:]
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[]")
+			if end < 0 {
+				end = len(rest)
+			}
+			name := rest[:end]
+			if name == "" {
+				return nil, invalid("empty member name")
+			}
+			if name == "*" {
+				path = append(path, jsonStep{wildcard: true})
+			} else {
+				path = append(path, jsonStep{name: name})
+			}
+			rest = rest[end:]
+		case '[':
+			end := strings.Index(rest, "]")
+			if end < 0 {
+				return nil, invalid("unclosed [")
+			}
+			inner := rest[1:end]
+			rest = rest[end+1:]
+			switch {
+			case inner == "*":
+				path = append(path, jsonStep{wildcard: true})
+			case len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0]:
+				path = append(path, jsonStep{name: inner[1 : len(inner)-1]})
+			default:
+				index, err := strconv.Atoi(inner)
+				if err != nil {
+					return nil, invalid("bad index " + inner)
+				}
+				path = append(path, jsonStep{index: index, isIndex: true})
+			}
+		default:
+			return nil, invalid("unexpected " + rest[:1])
+		}
+	}
+	return path, nil
+}
+
+// selectValues values of doc selected by the path
+func (p jsonPath) selectValues(doc interface{}) []interface{} {
+	current := []interface{}{doc}
+	for _, step := range p {
+		next := []interface{}{}
+		for _, v := range current {
+			switch node := v.(type) {
+			case map[string]interface{}:
+				if step.wildcard {
+					for _, child := range node {
+						next = append(next, child)
+					}
+				} else if child, ok := node[step.name]; ok && !step.isIndex {
+					next = append(next, child)
+				}
+			case []interface{}:
+				if step.wildcard {
+					next = append(next, node...)
+				} else if step.isIndex {
+					i := step.index
+					if i < 0 {
+						i += len(node)
+					}
+					if i >= 0 && i < len(node) {
+						next = append(next, node[i])
+					}
+				}
+			}
+		}
+		current = next
+	}
+	return current
+}
+
+// selectStrings selected values as strings: strings as-is, other values as JSON
+func (p jsonPath) selectStrings(doc interface{}) []string {
+	values := []string{}
+	for _, v := range p.selectValues(doc) {
+		if s, ok := v.(string); ok {
+			values = append(values, s)
+			continue
+		}
+		b, _ := json.Marshal(v)
+		values = append(values, string(b))
+	}
+	return values
+}
@@ -0,0 +1,63 @@
+package mutux
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestParseJSONPath(t *testing.T) {
+	doc := map[string]interface{}{}
+	err := json.Unmarshal([]byte(`{"user":{"name":"bob","tags":["a","b"]},"items":[{"id":1},{"id":2}],"dotted.key":true}`), &doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		expr string
+		want []string
+	}{
+		{"$", []string{`{"dotted.key":true,"items":[{"id":1},{"id":2}],"user":{"name":"bob","tags":["a","b"]}}`}},
+		{"$.user.name", []string{"bob"}},
+		{"$['user']['name']", []string{"bob"}},
+		{`$["dotted.key"]`, []string{"true"}},
+		{"$.user.tags[1]", []string{"b"}},
+		{"$.user.tags[-1]", []string{"b"}},
+		{"$.user.tags[5]", []string{}},
+		{"$.items[*].id", []string{"1", "2"}},
+		{"$.items.*.id", []string{"1", "2"}},
+		{"$.missing.name", []string{}},
+		{"$.user.name[0]", []string{}},
+	}
+	for _, test := range tests {
+		p, err := parseJSONPath(test.expr)
+		if err != nil {
+			t.Errorf("parseJSONPath(%q): %s", test.expr, err)
+			continue
+		}
+		if got := p.selectStrings(doc); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("parseJSONPath(%q) selected %q, want %q", test.expr, got, test.want)
+		}
+	}
+}
+
+func TestParseJSONPathInvalid(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"user.name",
+		"$.",
+		"$..name",
+		"$.user.",
+		"$[",
+		"$[0",
+		"$[]",
+		"$[a]",
+		"$['a]",
+		"$]",
+		"$name",
+		"$.a]",
+	} {
+		if _, err := parseJSONPath(expr); err == nil {
+			t.Errorf("parseJSONPath(%q) accepted", expr)
+		}
+	}
+}
@@ -0,0 +1,293 @@
+package mutux
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultShutdownTimeout time Stop, and cancellation of the context given to StartContext,
+// give requests in flight to complete
+const DefaultShutdownTimeout = 5 * time.Second
+
+// run one serving of the server, from Start until Serve returns
+type run struct {
+	ready    chan struct{}
+	done     chan struct{}
+	closing  chan struct{}
+	drained  chan struct{}
+	started  bool
+	stopping bool
+	shutdown bool
+	err      error
+}
+
+func newRun() *run {
+	return &run{
+		ready:   make(chan struct{}),
+		done:    make(chan struct{}),
+		closing: make(chan struct{}),
+		drained: make(chan struct{}),
+	}
+}
+
+// lifecycle synchronized state of the current run; a new run begins when a stopped server is started again
+type lifecycle struct {
+	mu       sync.Mutex
+	run      *run
+	shutdown bool
+	// serverMu guards the Listener and Server of the Mutux, which runs replace
+	serverMu sync.Mutex
+}
+
+func newLifecycle() *lifecycle {
+	return &lifecycle{run: newRun()}
+}
+
+// begin mark the current run started, or begin a new one if it is over. renew is set if the
+// http.Server was shut down, and must be replaced since it cannot serve again.
+func (l *lifecycle) begin() (r *run, renew bool, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.run.started {
+		select {
+		case <-l.run.done:
+			l.run = newRun()
+		default:
+			return nil, false, fmt.Errorf("server already started")
+		}
+	} else if l.run.stopping {
+		// stopped before it was ever started
+		l.run = newRun()
+	}
+	l.run.started = true
+	renew = l.shutdown
+	l.shutdown = false
+	return l.run, renew, nil
+}
+
+// stop mark the current run as stopped on purpose, so that Serve returning is not an error.
+// If shutdown is set, requests held by hanging faults are released too.
+func (l *lifecycle) stop(shutdown bool) *run {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	r := l.run
+	r.stopping = true
+	if shutdown && !r.shutdown {
+		l.shutdown = true
+		r.shutdown = true
+		close(r.closing)
+	}
+	return r
+}
+
+// awaitDrained wait for the shutdown of r to complete, if it is shutting down
+func (l *lifecycle) awaitDrained(r *run) {
+	l.mu.Lock()
+	shutdown := r.shutdown
+	l.mu.Unlock()
+	if shutdown {
+		<-r.drained
+	}
+}
+
+// markDrained mark the shutdown of r complete
+func (l *lifecycle) markDrained(r *run) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	select {
+	case <-r.drained:
+	default:
+		close(r.drained)
+	}
+}
+
+// finish record the error Serve returned, unless the server was stopped on purpose, and end the run
+func (l *lifecycle) finish(r *run, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !r.stopping && err != http.ErrServerClosed {
+		r.err = err
+	}
+	close(r.done)
+}
+
+func (l *lifecycle) current() *run {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.run
+}
+
+// closing channel closed when the current run shuts down
+func (l *lifecycle) closing() <-chan struct{} {
+	return l.current().closing
+}
+
+// wait block until r is over if it was started, returning the error Serve returned
+func (l *lifecycle) wait(r *run) error {
+	l.mu.Lock()
+	started := r.started
+	l.mu.Unlock()
+	if !started {
+		return nil
+	}
+	<-r.done
+	return r.err
+}
+
+// Start start Mutux server in go routine
+func (m *Mutux) Start() error {
+	return m.StartContext(context.Background())
+}
+
+// StartContext start Mutux server in go routine, and shut it down gracefully once ctx is done.
+// Errors binding the listener or loading the TLS certificate are returned; Wait returns
+// the error the server stops with later, and Ready fires once it serves.
+func (m *Mutux) StartContext(ctx context.Context) error {
+	if m == nil {
+		return nil
+	}
+	r, renew, err := m.lifecycle.begin()
+	if err != nil {
+		return err
+	}
+	server, listener, useTLS, err := m.prepareServe(renew)
+	if err != nil {
+		m.lifecycle.finish(r, err)
+		return err
+	}
+	logf(LogInfo, "starting server\n")
+	go func() {
+		// the listener is bound already, so connections are accepted from here on
+		close(r.ready)
+		var serveErr error
+		if useTLS {
+			serveErr = server.ServeTLS(listener, "", "")
+		} else {
+			serveErr = server.Serve(listener)
+		}
+		// the run is over only once requests in flight have completed
+		m.lifecycle.awaitDrained(r)
+		m.lifecycle.finish(r, serveErr)
+	}()
+	if ctx.Done() != nil {
+		go func() {
+			select {
+			case <-ctx.Done():
+				shutdownCtx, cancel := context.WithTimeout(context.Background(), DefaultShutdownTimeout)
+				defer cancel()
+				m.Shutdown(shutdownCtx)
+			case <-r.done:
+			}
+		}()
+	}
+	return nil
+}
+
+// prepareServe return the server and listener to serve with, replacing the server if renew is set and
+// binding the listener if it is closed, and whether to serve TLS
+func (m *Mutux) prepareServe(renew bool) (*http.Server, net.Listener, bool, error) {
+	m.lifecycle.serverMu.Lock()
+	defer m.lifecycle.serverMu.Unlock()
+	if renew {
+		m.Server = &http.Server{Addr: m.Address, Handler: m.handler}
+	}
+	if m.Listener == nil {
+		err := m.remakeListener()
+		if err != nil {
+			return nil, nil, false, err
+		}
+	}
+	useTLS := m.Certfile != "" && m.Keyfile != ""
+	if useTLS {
+		// load the certificate now, so that failing to is reported here rather than by Serve
+		cert, err := tls.LoadX509KeyPair(m.Certfile, m.Keyfile)
+		if err != nil {
+			return nil, nil, false, fmt.Errorf("Failed to load TLS certificate: %s", err.Error())
+		}
+		config := &tls.Config{}
+		if m.Server.TLSConfig != nil {
+			config = m.Server.TLSConfig.Clone()
+		}
+		config.Certificates = []tls.Certificate{cert}
+		m.Server.TLSConfig = config
+		if m.Server.TLSNextProto == nil {
+			// serve HTTP/1.1 only: drop and malformed faults hijack the connection, which HTTP/2 does not allow
+			m.Server.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+		}
+	}
+	return m.Server, *m.Listener, useTLS, nil
+}
+
+// StartAndHold start Mutux server in current process, returning once it stops;
+// the error is nil if it was stopped with Stop or Shutdown
+func (m *Mutux) StartAndHold() error {
+	if m == nil {
+		return nil
+	}
+	err := m.Start()
+	if err != nil {
+		return err
+	}
+	logf(LogInfo, "starting server in current process\n")
+	return m.Wait()
+}
+
+// Ready return a channel closed once the server accepts connections, after the next or current Start
+func (m *Mutux) Ready() <-chan struct{} {
+	if m == nil {
+		return nil
+	}
+	return m.lifecycle.current().ready
+}
+
+// Wait block until the server stops, and return the error it stopped with; nil if it was
+// stopped with Stop or Shutdown, or not started
+func (m *Mutux) Wait() error {
+	if m == nil {
+		return nil
+	}
+	return m.lifecycle.wait(m.lifecycle.current())
+}
+
+// Shutdown stop Mutux server gracefully: stop accepting connections, close idle ones, and
+// wait for requests in flight to complete. Requests held by hanging faults are released.
+// If ctx is done first, remaining connections are closed and its error returned.
+func (m *Mutux) Shutdown(ctx context.Context) error {
+	if m == nil {
+		return nil
+	}
+	m.StopWatching()
+	r := m.lifecycle.stop(true)
+	logf(LogInfo, "\nShutting down server\n")
+	m.lifecycle.serverMu.Lock()
+	server, listener := m.Server, m.Listener
+	m.Listener = nil
+	m.lifecycle.serverMu.Unlock()
+	err := server.Shutdown(ctx)
+	if err != nil {
+		server.Close()
+	}
+	// the listener is not closed by the server if it never served
+	if listener != nil {
+		(*listener).Close()
+	}
+	m.lifecycle.markDrained(r)
+	m.lifecycle.wait(r)
+	return err
+}
+
+// Stop stop Mutux server gracefully, giving requests in flight DefaultShutdownTimeout to complete,
+// and stop watching stub directories
+func (m *Mutux) Stop() error {
+	if m == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultShutdownTimeout)
+	defer cancel()
+	return m.Shutdown(ctx)
+}
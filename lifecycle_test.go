@@ -0,0 +1,163 @@
+package mutux
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newStoppedServer create a quiet server on a free loopback port without starting it
+func newStoppedServer(t *testing.T) *Mutux {
+	t.Helper()
+	quiet(t)
+	m, err := NewMutuxWithAddr("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		http.DefaultClient.CloseIdleConnections()
+		m.Stop()
+	})
+	return m
+}
+
+// waitDone fail the test unless f returns within a few seconds
+func waitDone(t *testing.T, what string, f func()) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		f()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatalf("%s did not return", what)
+	}
+}
+
+// TestContextCancelStop cancel the context of a started server, then stop it; run with go test -race
+func TestContextCancelStop(t *testing.T) {
+	m := newStoppedServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	err := m.StartContext(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-m.Ready()
+	cancel()
+	err = m.Stop()
+	if err != nil {
+		t.Errorf("Stop after cancel = %s", err.Error())
+	}
+	waitDone(t, "Wait after cancel", func() {
+		if err := m.Wait(); err != nil {
+			t.Errorf("Wait after cancel = %s, want nil", err.Error())
+		}
+	})
+}
+
+// TestConcurrentStop stop a server from several goroutines at once, while others wait for it
+func TestConcurrentStop(t *testing.T) {
+	m := newStoppedServer(t)
+	err := m.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(4)
+		go func() {
+			defer wg.Done()
+			m.Stop()
+		}()
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			m.Shutdown(ctx)
+		}()
+		go func() {
+			defer wg.Done()
+			<-m.Ready()
+		}()
+		go func() {
+			defer wg.Done()
+			if err := m.Wait(); err != nil {
+				t.Errorf("Wait = %s, want nil", err.Error())
+			}
+		}()
+	}
+	waitDone(t, "concurrent Stop", wg.Wait)
+
+	// a stopped server starts again
+	err = m.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-m.Ready()
+	m.AddPathMsg("again", "again")
+	if _, body := get(t, m, "/again"); body != "again" {
+		t.Errorf("GET /again after restarting = %q", body)
+	}
+}
+
+// TestRestartWhileServing restart a server while requests are sent and it is waited for
+func TestRestartWhileServing(t *testing.T) {
+	m := newStoppedServer(t)
+	err := m.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-m.Ready()
+	m.AddPathMsg("hello", "hello")
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			// requests may fail while the listener is rebound
+			if resp, err := http.Get(m.URL() + "/hello"); err == nil {
+				resp.Body.Close()
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-m.Ready():
+			}
+			m.Wait()
+		}
+	}()
+	for i := 0; i < 3; i++ {
+		err = m.Restart()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(stop)
+	m.Stop()
+	waitDone(t, "serving goroutines", wg.Wait)
+
+	err = m.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-m.Ready()
+	if _, body := get(t, m, "/hello"); body != "hello" {
+		t.Errorf("GET /hello after restarts = %q", body)
+	}
+}
@@ -0,0 +1,57 @@
+package mutux
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// LogLevel verbosity of what Mutux prints to stdout
+type LogLevel int32
+
+// Log levels, from most to least verbose
+const (
+	// LogDebug print every response returned, as well as everything below
+	LogDebug LogLevel = iota
+	// LogInfo print server lifecycle and stub changes
+	LogInfo
+	// LogError print failures only
+	LogError
+	// LogOff print nothing
+	LogOff
+)
+
+var logLevelNames = []string{"debug", "info", "error", "off"}
+
+var logLevel = int32(LogDebug)
+
+// String name of the level, as accepted by ParseLogLevel
+func (l LogLevel) String() string {
+	if l < LogDebug || l > LogOff {
+		return fmt.Sprintf("LogLevel(%d)", int32(l))
+	}
+	return logLevelNames[l]
+}
+
+// ParseLogLevel parse "debug", "info", "error" or "off"
+func ParseLogLevel(s string) (LogLevel, error) {
+	for i, name := range logLevelNames {
+		if strings.EqualFold(s, name) {
+			return LogLevel(i), nil
+		}
+	}
+	return LogDebug, fmt.Errorf("unknown log level %q, expected one of %s", s, strings.Join(logLevelNames, ", "))
+}
+
+// SetLogLevel set the verbosity of all Mutux servers in the process; the default is LogDebug
+func SetLogLevel(level LogLevel) {
+	atomic.StoreInt32(&logLevel, int32(level))
+}
+
+// logf print to stdout if level is enabled
+func logf(level LogLevel, format string, args ...interface{}) {
+	if level < LogLevel(atomic.LoadInt32(&logLevel)) {
+		return
+	}
+	fmt.Printf(format, args...)
+}
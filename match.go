@@ -0,0 +1,322 @@
+package mutux
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Match request conditions a stub must satisfy besides its path.
+// A stub without conditions answers any request to its path, and is used as fallback
+// when no more specific stub matches.
+//
+// Of the stubs matching a request, the one with the highest Priority wins; on equal priority
+// the most specific conditions win, and then the latest added stub.
+type Match struct {
+	// Method HTTP method of the request; empty matches any method. A GET stub also answers HEAD.
+	Method         string                  `json:"method,omitempty"`
+	Query          map[string]ValueMatcher `json:"query,omitempty"`
+	RequestHeaders map[string]ValueMatcher `json:"requestHeaders,omitempty"`
+	Cookies        map[string]ValueMatcher `json:"cookies,omitempty"`
+	Body           *ValueMatcher           `json:"body,omitempty"`
+	// JSONPath conditions on values selected from a JSON body, e.g. {"$.method":"getUser"}
+	JSONPath map[string]ValueMatcher `json:"jsonPath,omitempty"`
+	// XPath conditions on values selected from an XML body, e.g. {"//Body/GetUser/@id":"1"}
+	XPath    map[string]ValueMatcher `json:"xPath,omitempty"`
+	Priority int                     `json:"priority,omitempty"`
+}
+
+// ValueMatcher condition on a request value: a query param, header, cookie, body, or a value
+// selected from the body. In JSON it may also be given as a plain string, which is shorthand
+// for {"equals":"..."}. Where a value occurs several times, any occurrence may satisfy it.
+type ValueMatcher struct {
+	// Equals value must be exactly this
+	Equals *string `json:"equals,omitempty"`
+	// Matches value must match this regular expression
+	Matches string `json:"matches,omitempty"`
+	// Present value must be present if true, absent if false
+	Present *bool `json:"present,omitempty"`
+	// re Matches compiled when the stub is added
+	re *regexp.Regexp
+}
+
+// UnmarshalJSON accept a plain string as an Equals matcher
+func (v *ValueMatcher) UnmarshalJSON(b []byte) error {
+	var equals string
+	if json.Unmarshal(b, &equals) == nil {
+		*v = ValueMatcher{Equals: &equals}
+		return nil
+	}
+	type plain ValueMatcher
+	return json.Unmarshal(b, (*plain)(v))
+}
+
+// Equals matcher for a value that must be exactly value
+func Equals(value string) ValueMatcher {
+	return ValueMatcher{Equals: &value}
+}
+
+// Matches matcher for a value matching regular expression pattern
+func Matches(pattern string) ValueMatcher {
+	return ValueMatcher{Matches: pattern}
+}
+
+// Present matcher for a value that must be present
+func Present() ValueMatcher {
+	present := true
+	return ValueMatcher{Present: &present}
+}
+
+// Absent matcher for a value that must be absent
+func Absent() ValueMatcher {
+	present := false
+	return ValueMatcher{Present: &present}
+}
+
+// match return whether values satisfy the condition, and how specific it is;
+// exact values outweigh regular expressions, which outweigh presence checks
+func (v ValueMatcher) match(values []string) (bool, int) {
+	score := 0
+	if v.Present != nil {
+		if (len(values) > 0) != *v.Present {
+			return false, 0
+		}
+		score++
+	}
+	if v.Equals != nil {
+		if !containsString(values, *v.Equals) {
+			return false, 0
+		}
+		score += 3
+	}
+	if v.Matches != "" {
+		if v.re == nil || !anyMatches(values, v.re) {
+			return false, 0
+		}
+		score += 2
+	}
+	return true, score
+}
+
+// compile return v with its regular expression compiled
+func (v ValueMatcher) compile() (ValueMatcher, error) {
+	if v.Matches == "" {
+		return v, nil
+	}
+	re, err := regexp.Compile(v.Matches)
+	if err != nil {
+		return v, err
+	}
+	v.re = re
+	return v, nil
+}
+
+// queryMatch exact-value query matchers parsed from the parameters of path, if any
+func queryMatch(path string) (Match, error) {
+	match := Match{}
+	i := strings.Index(path, "?")
+	if i < 0 {
+		return match, nil
+	}
+	values, err := url.ParseQuery(path[i+1:])
+	if err != nil {
+		return match, fmt.Errorf("invalid query in %s: %s", path, err.Error())
+	}
+	for k := range values {
+		if match.Query == nil {
+			match.Query = map[string]ValueMatcher{}
+		}
+		match.Query[k] = Equals(values.Get(k))
+	}
+	return match, nil
+}
+
+// compile return m with all regular expressions compiled, so that requests do not compile them,
+// and check that its body paths parse
+func (m Match) compile() (Match, error) {
+	for _, c := range []struct {
+		kind     string
+		matchers *map[string]ValueMatcher
+	}{
+		{"query param", &m.Query},
+		{"request header", &m.RequestHeaders},
+		{"cookie", &m.Cookies},
+		{"JSONPath", &m.JSONPath},
+		{"XPath", &m.XPath},
+	} {
+		if *c.matchers == nil {
+			continue
+		}
+		// copy the map, which the caller may still hold
+		compiled := make(map[string]ValueMatcher, len(*c.matchers))
+		for k, v := range *c.matchers {
+			v, err := v.compile()
+			if err != nil {
+				return m, fmt.Errorf("invalid regex for %s %s: %s", c.kind, k, err.Error())
+			}
+			compiled[k] = v
+		}
+		*c.matchers = compiled
+	}
+	if m.Body != nil {
+		body, err := m.Body.compile()
+		if err != nil {
+			return m, fmt.Errorf("invalid regex for body: %s", err.Error())
+		}
+		m.Body = &body
+	}
+	for expr := range m.JSONPath {
+		if _, err := parseJSONPath(expr); err != nil {
+			return m, err
+		}
+	}
+	for expr := range m.XPath {
+		if _, err := parseXPath(expr); err != nil {
+			return m, err
+		}
+	}
+	return m, nil
+}
+
+// key canonical form of the conditions; stubs on the same path with the same key replace each other
+func (m Match) key() string {
+	b, _ := json.Marshal(m)
+	return string(b)
+}
+
+// matchRequest request being matched against stubs, with its body read once and parsed on demand
+type matchRequest struct {
+	*http.Request
+	body    []byte
+	json    interface{}
+	jsonErr error
+	jsonOK  bool
+	xml     *xmlNode
+	xmlErr  error
+	xmlOK   bool
+}
+
+// newMatchRequest read the body of r, leaving it in place for later handlers
+func newMatchRequest(r *http.Request) *matchRequest {
+	body, _ := ioutil.ReadAll(r.Body)
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return &matchRequest{Request: r, body: body}
+}
+
+func (r *matchRequest) jsonBody() (interface{}, error) {
+	if !r.jsonOK {
+		r.jsonOK = true
+		r.jsonErr = json.Unmarshal(r.body, &r.json)
+	}
+	return r.json, r.jsonErr
+}
+
+func (r *matchRequest) xmlBody() (*xmlNode, error) {
+	if !r.xmlOK {
+		r.xmlOK = true
+		r.xml, r.xmlErr = parseXML(r.body)
+	}
+	return r.xml, r.xmlErr
+}
+
+// matches return whether r satisfies all conditions, and how specific they are;
+// exact values and methods outweigh regular expressions, which outweigh presence checks
+func (m Match) matches(r *matchRequest) (bool, int) {
+	score := 0
+	switch {
+	case m.Method == "":
+	case m.Method == r.Method:
+		score += 3
+	case m.Method == http.MethodGet && r.Method == http.MethodHead:
+		score += 2
+	default:
+		return false, 0
+	}
+	query := r.URL.Query()
+	for k, v := range m.Query {
+		if !addScore(&score)(v.match(query[k])) {
+			return false, 0
+		}
+	}
+	for k, v := range m.RequestHeaders {
+		if !addScore(&score)(v.match(r.Header[http.CanonicalHeaderKey(k)])) {
+			return false, 0
+		}
+	}
+	for k, v := range m.Cookies {
+		values := []string{}
+		for _, c := range r.Cookies() {
+			if c.Name == k {
+				values = append(values, c.Value)
+			}
+		}
+		if !addScore(&score)(v.match(values)) {
+			return false, 0
+		}
+	}
+	if m.Body != nil {
+		values := []string{}
+		if len(r.body) > 0 {
+			values = append(values, string(r.body))
+		}
+		if !addScore(&score)(m.Body.match(values)) {
+			return false, 0
+		}
+	}
+	if len(m.JSONPath) > 0 {
+		doc, err := r.jsonBody()
+		if err != nil {
+			return false, 0
+		}
+		for expr, v := range m.JSONPath {
+			path, _ := parseJSONPath(expr)
+			if !addScore(&score)(v.match(path.selectStrings(doc))) {
+				return false, 0
+			}
+		}
+	}
+	if len(m.XPath) > 0 {
+		doc, err := r.xmlBody()
+		if err != nil {
+			return false, 0
+		}
+		for expr, v := range m.XPath {
+			path, _ := parseXPath(expr)
+			if !addScore(&score)(v.match(path.selectStrings(doc))) {
+				return false, 0
+			}
+		}
+	}
+	return true, score
+}
+
+// addScore return a func adding the score of a successful match to score
+func addScore(score *int) func(bool, int) bool {
+	return func(ok bool, s int) bool {
+		*score += s
+		return ok
+	}
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func anyMatches(values []string, re *regexp.Regexp) bool {
+	for _, v := range values {
+		if re.MatchString(v) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,116 @@
+package mutux
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMatchConditions(t *testing.T) {
+	for _, c := range []struct {
+		name  string
+		match Match
+		req   func() *http.Request
+		want  bool
+	}{
+		{"any", Match{}, getRequest("/"), true},
+		{"method", Match{Method: "POST"}, getRequest("/"), false},
+		{"GET answers HEAD", Match{Method: "GET"}, request("HEAD", "/", ""), true},
+		{"query equals", Match{Query: map[string]ValueMatcher{"id": Equals("1")}}, getRequest("/?id=2&id=1"), true},
+		{"query differs", Match{Query: map[string]ValueMatcher{"id": Equals("1")}}, getRequest("/?id=2"), false},
+		{"query regex", Match{Query: map[string]ValueMatcher{"id": Matches("^[0-9]+$")}}, getRequest("/?id=42"), true},
+		{"query regex differs", Match{Query: map[string]ValueMatcher{"id": Matches("^[0-9]+$")}}, getRequest("/?id=x"), false},
+		{"query present", Match{Query: map[string]ValueMatcher{"id": Present()}}, getRequest("/?id="), true},
+		{"query absent", Match{Query: map[string]ValueMatcher{"id": Absent()}}, getRequest("/?id=1"), false},
+		{"header", Match{RequestHeaders: map[string]ValueMatcher{"x-token": Matches("^abc")}}, headerRequest("X-Token", "abcdef"), true},
+		{"header missing", Match{RequestHeaders: map[string]ValueMatcher{"X-Token": Present()}}, getRequest("/"), false},
+		{"cookie", Match{Cookies: map[string]ValueMatcher{"session": Equals("s1")}}, headerRequest("Cookie", "session=s1"), true},
+		{"body regex", Match{Body: &ValueMatcher{Matches: "hello"}}, request("POST", "/", "say hello"), true},
+		{"body absent", Match{Body: &ValueMatcher{Present: new(bool)}}, request("POST", "/", "body"), false},
+		{"JSONPath", Match{JSONPath: map[string]ValueMatcher{"$.user.id": Equals("7")}}, request("POST", "/", `{"user":{"id":7}}`), true},
+		{"JSONPath on invalid JSON", Match{JSONPath: map[string]ValueMatcher{"$.id": Present()}}, request("POST", "/", "{"), false},
+		{"XPath", Match{XPath: map[string]ValueMatcher{"//user/@id": Matches("^7$")}}, request("POST", "/", `<user id="7"/>`), true},
+	} {
+		match, err := c.match.compile()
+		if err != nil {
+			t.Fatalf("%s: %s", c.name, err.Error())
+		}
+		if got, _ := match.matches(newMatchRequest(c.req())); got != c.want {
+			t.Errorf("%s: matches = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+// TestStubSelection check which stub answers when several on a path match
+func TestStubSelection(t *testing.T) {
+	for _, c := range []struct {
+		name  string
+		stubs []Message
+		req   func() *http.Request
+		want  string
+	}{
+		{"fallback", []Message{stub("fallback", Match{}), stub("one", Match{Query: map[string]ValueMatcher{"id": Equals("1")}})}, getRequest("/p?id=2"), "fallback"},
+		{"specific over fallback", []Message{stub("one", Match{Query: map[string]ValueMatcher{"id": Equals("1")}}), stub("fallback", Match{})}, getRequest("/p?id=1"), "one"},
+		{"equals over regex", []Message{stub("equals", Match{Query: map[string]ValueMatcher{"id": Equals("1")}}), stub("regex", Match{Query: map[string]ValueMatcher{"id": Matches("1")}})}, getRequest("/p?id=1"), "equals"},
+		{"method over any", []Message{stub("get", Match{Method: "GET"}), stub("any", Match{})}, getRequest("/p"), "get"},
+		{"priority over specificity", []Message{stub("low", Match{Query: map[string]ValueMatcher{"id": Equals("1")}}), stub("high", Match{Priority: 1})}, getRequest("/p?id=1"), "high"},
+		{"latest on a tie", []Message{stub("first", Match{Query: map[string]ValueMatcher{"a": Present()}}), stub("second", Match{Query: map[string]ValueMatcher{"b": Present()}})}, getRequest("/p?a=1&b=1"), "second"},
+		{"no match", []Message{stub("post", Match{Method: "POST"})}, getRequest("/p"), ""},
+	} {
+		store := newStubStore()
+		for _, msg := range c.stubs {
+			path, msg, err := prepareStub("p", msg)
+			if err != nil {
+				t.Fatalf("%s: %s", c.name, err.Error())
+			}
+			store.setMsg(path, msg)
+		}
+		got := ""
+		if s, _, found := store.match("p", newMatchRequest(c.req())); found {
+			got = *s.Msg
+		}
+		if got != c.want {
+			t.Errorf("%s: answered by %q, want %q", c.name, got, c.want)
+		}
+	}
+}
+
+func TestInvalidRegex(t *testing.T) {
+	m := newTestServer(t)
+	_, err := m.AddStub("bad", stub("bad", Match{Query: map[string]ValueMatcher{"id": Matches("(")}}))
+	if err == nil || !strings.Contains(err.Error(), "invalid regex for query param id") {
+		t.Errorf("AddStub with invalid regex = %v, want an error", err)
+	}
+	m.EnablePUT()
+	for _, path := range []string{"/bad", AdminPrefix + "/stubs/bad"} {
+		if status, body := put(t, m, path, `{"message":"bad","body":{"matches":"("}}`); status != http.StatusBadRequest {
+			t.Errorf("PUT %s with invalid regex = %d %s, want 400", path, status, body)
+		}
+	}
+	if len(m.Stubs()) != 0 {
+		t.Errorf("stubs added with invalid regex: %v", m.Stubs())
+	}
+}
+
+func stub(msg string, match Match) Message {
+	return Message{Response: Response{Msg: &msg}, Match: match}
+}
+
+func getRequest(target string) func() *http.Request {
+	return request(http.MethodGet, target, "")
+}
+
+func request(method, target, body string) func() *http.Request {
+	return func() *http.Request {
+		return httptest.NewRequest(method, target, strings.NewReader(body))
+	}
+}
+
+func headerRequest(name, value string) func() *http.Request {
+	return func() *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set(name, value)
+		return r
+	}
+}
@@ -0,0 +1,242 @@
+package mutux
+
+import (
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Mount file tree served under a path prefix, to GET and HEAD requests that no stub matches.
+// Dir is a directory on the server; FS, set from Go only, serves any fs.FS instead.
+// A directory is answered with its index.html, or a listing if List is set; otherwise, and for
+// missing files, the unmatched response is written. Global headers are not added to files.
+type Mount struct {
+	Prefix string `json:"prefix"`
+	Dir    string `json:"dir,omitempty"`
+	FS     fs.FS  `json:"-"`
+	List   bool   `json:"list,omitempty"`
+	// fsys tree served, FS or Dir opened
+	fsys fs.FS
+	// source watched directory the mount was loaded from, if any
+	source string
+}
+
+// mounts synchronized set of mounts, longest prefix first
+type mounts struct {
+	mu   sync.RWMutex
+	list []Mount
+}
+
+func newMounts() *mounts {
+	return &mounts{}
+}
+
+// prepareMount clean the prefix of mt to "/" and the path without trailing slash, and open its tree
+func prepareMount(mt Mount) (Mount, error) {
+	prefix := strings.TrimSuffix(trimPath(mt.Prefix), "/")
+	if isPattern(prefix) {
+		return mt, fmt.Errorf("mount prefix %s must not be a pattern", mt.Prefix)
+	}
+	mt.Prefix = "/" + prefix
+	switch {
+	case mt.FS != nil && mt.Dir != "":
+		return mt, fmt.Errorf("only one of dir and fs may be set")
+	case mt.FS != nil:
+		mt.fsys = mt.FS
+	case mt.Dir != "":
+		info, err := os.Stat(mt.Dir)
+		if err != nil {
+			return mt, fmt.Errorf("Error reading mount dir: %s", err.Error())
+		}
+		if !info.IsDir() {
+			return mt, fmt.Errorf("mount dir %s is not a directory", mt.Dir)
+		}
+		mt.fsys = os.DirFS(mt.Dir)
+	default:
+		return mt, fmt.Errorf("mount dir is empty")
+	}
+	return mt, nil
+}
+
+// set add mt, prepared already, replacing the mount on the same prefix
+func (s *mounts) set(mt Mount) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.add(mt)
+}
+
+// add add mt, replacing the mount on the same prefix; caller must hold mu
+func (s *mounts) add(mt Mount) {
+	list := []Mount{mt}
+	for _, old := range s.list {
+		if old.Prefix != mt.Prefix {
+			list = append(list, old)
+		}
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		return len(list[i].Prefix) > len(list[j].Prefix)
+	})
+	s.list = list
+}
+
+// replaceSource replace the mounts loaded from source with list, prepared already
+func (s *mounts) replaceSource(source string, list []Mount) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kept := []Mount{}
+	for _, mt := range s.list {
+		if mt.source != source {
+			kept = append(kept, mt)
+		}
+	}
+	s.list = kept
+	for _, mt := range list {
+		mt.source = source
+		s.add(mt)
+	}
+}
+
+// reset delete all mounts
+func (s *mounts) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.list = nil
+}
+
+// del delete the mount on prefix, returning whether it existed
+func (s *mounts) del(prefix string) bool {
+	prefix = "/" + strings.TrimSuffix(trimPath(prefix), "/")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, mt := range s.list {
+		if mt.Prefix == prefix {
+			s.list = append(s.list[:i:i], s.list[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// all return a copy of the mounts, longest prefix first
+func (s *mounts) all() []Mount {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]Mount{}, s.list...)
+}
+
+// serve answer r, requested at path, trimmed already, from the mount with the longest prefix
+// holding it, comparing prefixes under paths; return whether a mount answered
+func (s *mounts) serve(w http.ResponseWriter, r *http.Request, path string, paths PathOptions) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	for _, mt := range s.all() {
+		rest, ok := mt.strip(path, paths)
+		if ok && mt.serveFile(w, r, rest) {
+			setMount(r, mt.Prefix)
+			return true
+		}
+	}
+	return false
+}
+
+// strip return path, trimmed already, without the prefix of mt, and whether it has that prefix;
+// the rest is empty or starts with "/"
+func (mt Mount) strip(path string, paths PathOptions) (string, bool) {
+	prefix := strings.TrimPrefix(mt.Prefix, "/")
+	if len(path) < len(prefix) {
+		return "", false
+	}
+	head, rest := path[:len(prefix)], path[len(prefix):]
+	if head != prefix && !(paths.CaseInsensitive && strings.EqualFold(head, prefix)) {
+		return "", false
+	}
+	if prefix == "" {
+		return "/" + rest, true
+	}
+	if rest != "" && rest[0] != '/' {
+		return "", false
+	}
+	return rest, true
+}
+
+// serveFile answer r with the file at rest within mt, if it is there and may be served
+func (mt Mount) serveFile(w http.ResponseWriter, r *http.Request, rest string) bool {
+	name := strings.Trim(rest, "/")
+	if name == "" {
+		name = "."
+	}
+	if !fs.ValidPath(name) {
+		return false
+	}
+	info, err := fs.Stat(mt.fsys, name)
+	if err != nil {
+		return false
+	}
+	if info.IsDir() && !mt.List {
+		if _, err := fs.Stat(mt.fsys, path.Join(name, "index.html")); err != nil {
+			return false
+		}
+	}
+	if rest == "" {
+		// the mount root without trailing slash; redirect so that relative links resolve under the prefix
+		target := *r.URL
+		target.Path += "/"
+		target.RawPath = ""
+		http.Redirect(w, r, target.String(), http.StatusMovedPermanently)
+		return true
+	}
+	req := *r
+	u := *r.URL
+	u.Path = rest
+	u.RawPath = ""
+	req.URL = &u
+	http.FileServer(http.FS(mt.fsys)).ServeHTTP(w, &req)
+	return true
+}
+
+// AddMount serve a file tree under a path prefix, to requests no stub matches; it replaces
+// the mount on the same prefix. The mount with the longest prefix holding a file answers.
+func (m *Mutux) AddMount(mt Mount) error {
+	if m == nil {
+		return nil
+	}
+	mt, err := prepareMount(mt)
+	if err != nil {
+		return fmt.Errorf("Failed to add mount: %s", err.Error())
+	}
+	logf(LogInfo, "mounting %s on %s\n", mt.Dir, mt.Prefix)
+	m.mounts.set(mt)
+	return nil
+}
+
+// MountDir serve the directory dir under a path prefix, without directory listings
+func (m *Mutux) MountDir(prefix, dir string) error {
+	return m.AddMount(Mount{Prefix: prefix, Dir: dir})
+}
+
+// MountFS serve fsys under a path prefix, without directory listings
+func (m *Mutux) MountFS(prefix string, fsys fs.FS) error {
+	return m.AddMount(Mount{Prefix: prefix, FS: fsys})
+}
+
+// Unmount stop serving the mount on prefix, returning whether it existed
+func (m *Mutux) Unmount(prefix string) bool {
+	if m == nil {
+		return false
+	}
+	return m.mounts.del(prefix)
+}
+
+// Mounts return the mounts, longest prefix first
+func (m *Mutux) Mounts() []Mount {
+	if m == nil {
+		return nil
+	}
+	return m.mounts.all()
+}
@@ -5,8 +5,12 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"time"
+	"unicode/utf8"
 
 	"net/http"
 
@@ -14,26 +18,55 @@ import (
 )
 
 // Mutux a mutable server that can be set at runtime to return any message at any URL.
+// Handler is the current router, replaced whenever handler funcs change; CustomHandlerfuncs are the
+// user-defined handler funcs, changed with AddHandlerFunc, DelHandlerFunc and ClearHandlerFunc;
+// changes made to it directly take effect on Restart. Listener and Server are replaced as the server
+// starts, restarts and shuts down, so they are safe to change only while it is stopped.
+//
+// The Pathmsg, Headers and AllowPUT fields of earlier versions are replaced by the Pathmsg, Headers
+// and PUTAllowed methods, which return snapshots; Pathmsg holds every message on each path.
 type Mutux struct {
 	Address            string
 	Certfile           string
 	Keyfile            string
 	Listener           *net.Listener
 	Server             *http.Server
-	Pathmsg            map[string]Message
-	Headers            map[string]string
-	AllowPUT           *bool
 	Handler            *mux.Router
 	CustomHandlerfuncs []Handlerfunc
 	handlerfuncs       []Handlerfunc
+	handler            http.Handler
+	adminfuncs         []Handlerfunc
+	unmatchedfunc      *func(w http.ResponseWriter, r *http.Request)
+	adminPrefix        string
+	handlerMu          sync.Mutex
+	router             *routerSwitch
+	store              *stubStore
+	journal            *journal
+	faults             *faultRand
+	mounts             *mounts
+	watchers           []*dirWatcher
+	lifecycle          *lifecycle
+	watchMu            sync.Mutex
+	// configAddr address as given, before a free port was picked for port 0; saved by Config
+	configAddr string
 }
 
-// Message store message and status to return for a given path
+// Message store the response to return for a given path, and the conditions a request must match to get it.
+// If Responses is set, they are returned one per request instead, and Sequence decides what happens once all were returned.
+// Delay and Fault simulate a slow or flaky server. Expect declares how many requests the stub should answer, checked by Verify.
 type Message struct {
-	Msg    *string `json:"message"`
-	Status *int    `json:"status"`
+	Response
+	Responses []Response `json:"responses,omitempty"`
+	Sequence  string     `json:"sequence,omitempty"`
+	Delay     *Delay     `json:"delay,omitempty"`
+	Fault     *Fault     `json:"fault,omitempty"`
+	Expect    *Expect    `json:"expect,omitempty"`
+	Match
 }
 
+// stubRoute route of the message handlers, capturing the stub path, percent-decoded, as name
+const stubRoute = `/{name:.*}`
+
 // Handlerfunc store instances of handler function
 type Handlerfunc struct {
 	Route    string
@@ -41,11 +74,12 @@ type Handlerfunc struct {
 	Function *func(w http.ResponseWriter, r *http.Request)
 }
 
+// remakeListener bind a new listener; caller must hold lifecycle.serverMu
 func (m *Mutux) remakeListener() error {
 	if m == nil {
 		return nil
 	}
-	fmt.Printf("remaking listener...")
+	logf(LogInfo, "remaking listener...")
 	listener, err := net.Listen("tcp", m.Address)
 	if err != nil {
 		for i := 0; i < 100; i++ {
@@ -54,141 +88,350 @@ func (m *Mutux) remakeListener() error {
 			if err == nil {
 				break
 			}
-			fmt.Printf(".")
+			logf(LogInfo, ".")
 		}
 	}
 	if err != nil {
-		fmt.Println("\nFailed to remake listener after retries")
+		logf(LogError, "\nFailed to remake listener after retries\n")
 		return err
 	}
-	fmt.Println("\nSuccess remaking listener.")
+	logf(LogInfo, "\nSuccess remaking listener.\n")
 	m.Listener = &listener
 	return nil
 }
 
-// StartAndHold start Mutux server in current process
-func (m *Mutux) StartAndHold() error {
-	if m == nil {
-		return nil
-	}
-	if m.Listener == nil {
-		err := m.remakeListener()
+// closeListener close the listener, if any; caller must hold lifecycle.serverMu
+func (m *Mutux) closeListener() error {
+	if m.Listener != nil {
+		logf(LogInfo, "\nClosing server\n")
+		err := (*m.Listener).Close()
 		if err != nil {
 			return err
 		}
+		m.Listener = nil
 	}
-	fmt.Println("starting server in current process")
-	if m.Certfile != "" && m.Keyfile != "" {
-		return (*m.Server).ServeTLS((*m.Listener).(*net.TCPListener), m.Certfile, m.Keyfile)
+	return nil
+}
+
+// AddPathMsg add message to a URL path.
+// Query params in path, e.g. "items?page=1", must be present with the same values for the message to be returned;
+// a path without params answers any request not matched by a more specific message.
+func (m *Mutux) AddPathMsg(path, msg string) {
+	if m == nil {
+		return
+	}
+	status := 200
+	logf(LogInfo, "adding path /%s\n", strings.TrimLeft(path, "/"))
+	_, err := m.AddStub(path, Message{
+		Response: Response{
+			Msg:    &msg,
+			Status: &status,
+		},
+	})
+	if err != nil {
+		logf(LogError, "Failed to add path: %s\n", err.Error())
 	}
-	return (*m.Server).Serve((*m.Listener).(*net.TCPListener))
 }
 
-// Start start Mutux server in go routine
-func (m *Mutux) Start() error {
+// AddPathMsgAndStatus add message to a URL path, with specified status code.
+// Query params in path are matched as in AddPathMsg.
+func (m *Mutux) AddPathMsgAndStatus(path, msg string, status int) {
 	if m == nil {
-		return nil
+		return
 	}
-	if m.Listener == nil {
-		err := m.remakeListener()
-		if err != nil {
-			return err
+	logf(LogInfo, "adding path /%s with status %d\n", strings.TrimLeft(path, "/"), status)
+	_, err := m.AddStub(path, Message{
+		Response: Response{
+			Msg:    &msg,
+			Status: &status,
+		},
+	})
+	if err != nil {
+		logf(LogError, "Failed to add path: %s\n", err.Error())
+	}
+}
+
+// AddMethodPathMsg add message to a URL path, returned only for requests with method.
+// Messages added without method answer any method not matched by a method-specific message.
+func (m *Mutux) AddMethodPathMsg(method, path, msg string) {
+	if m == nil {
+		return
+	}
+	m.AddMethodPathMsgAndStatus(method, path, msg, 200)
+}
+
+// AddMethodPathMsgAndStatus add message to a URL path, with specified status code, returned only for requests with method
+func (m *Mutux) AddMethodPathMsgAndStatus(method, path, msg string, status int) {
+	if m == nil {
+		return
+	}
+	logf(LogInfo, "adding %s path /%s with status %d\n", strings.ToUpper(method), strings.TrimLeft(path, "/"), status)
+	_, err := m.AddStub(path, Message{
+		Response: Response{
+			Msg:    &msg,
+			Status: &status,
+		},
+		Match: Match{Method: method},
+	})
+	if err != nil {
+		logf(LogError, "Failed to add path: %s\n", err.Error())
+	}
+}
+
+// AddPathMsgAndHeaders add message to a URL path, with specified status code and response headers.
+// The headers are merged over the global headers.
+func (m *Mutux) AddPathMsgAndHeaders(path, msg string, status int, headers map[string]string) {
+	if m == nil {
+		return
+	}
+	values := HeaderValues{}
+	for k, v := range headers {
+		values[k] = []string{v}
+	}
+	logf(LogInfo, "adding path /%s with status %d and headers\n", strings.TrimLeft(path, "/"), status)
+	_, err := m.AddStub(path, Message{
+		Response: Response{
+			Msg:     &msg,
+			Status:  &status,
+			Headers: values,
+		},
+	})
+	if err != nil {
+		logf(LogError, "Failed to add path: %s\n", err.Error())
+	}
+}
+
+// AddStub add message to a URL path, returned for requests satisfying its match conditions.
+// Query params in path are added to the conditions as exact values.
+// A message with the same path and conditions as an existing one replaces it.
+func (m *Mutux) AddStub(path string, msg Message) (Stub, error) {
+	if m == nil {
+		return Stub{}, nil
+	}
+	path, msg, err := prepareStub(path, msg)
+	if err != nil {
+		return Stub{}, err
+	}
+	return m.store.setMsg(path, msg), nil
+}
+
+// prepareStub trim path, merge its query params into the match conditions of msg,
+// default status to 200, and validate the result
+func prepareStub(path string, msg Message) (string, Message, error) {
+	query, err := queryMatch(path)
+	if err != nil {
+		return "", msg, err
+	}
+	if len(query.Query) > 0 {
+		merged := map[string]ValueMatcher{}
+		for k, q := range msg.Query {
+			merged[k] = q
 		}
+		for k, q := range query.Query {
+			merged[k] = q
+		}
+		msg.Query = merged
 	}
-	fmt.Println("starting server")
-	if m.Certfile != "" && m.Keyfile != "" {
-		go (*m.Server).ServeTLS((*m.Listener).(*net.TCPListener), m.Certfile, m.Keyfile)
+	if len(msg.Responses) == 0 {
+		msg.Response, err = prepareResponse(msg.Response)
+		if err != nil {
+			return "", msg, err
+		}
 	} else {
-		go (*m.Server).Serve((*m.Listener).(*net.TCPListener))
+		responses := make([]Response, len(msg.Responses))
+		for i, resp := range msg.Responses {
+			responses[i], err = prepareResponse(resp)
+			if err != nil {
+				return "", msg, fmt.Errorf("response %d: %s", i+1, err.Error())
+			}
+		}
+		msg.Responses = responses
 	}
-	return nil
+	switch msg.Sequence {
+	case "", SequenceRepeatLast, SequenceCycle, SequenceNotFound:
+	default:
+		return "", msg, fmt.Errorf("unknown sequence %q", msg.Sequence)
+	}
+	err = msg.Delay.validate()
+	if err != nil {
+		return "", msg, err
+	}
+	err = msg.Fault.validate()
+	if err != nil {
+		return "", msg, err
+	}
+	err = msg.Expect.validate()
+	if err != nil {
+		return "", msg, err
+	}
+	msg.Method = strings.ToUpper(msg.Method)
+	msg.Match, err = msg.Match.compile()
+	if err != nil {
+		return "", msg, err
+	}
+	path = trimPath(path)
+	if isPattern(path) {
+		_, err = compilePattern(path, PathOptions{})
+		if err != nil {
+			return "", msg, err
+		}
+	}
+	return path, msg, nil
 }
 
-// Stop close Mutux server
-func (m *Mutux) Stop() error {
-	if m == nil {
-		return nil
+// prepareResponse default status of resp to 200, read its body file if any, and check it has exactly one body
+func prepareResponse(resp Response) (Response, error) {
+	switch {
+	case resp.BodyFile != "" && resp.BodyFileLive:
+		// read at each request; check now that it can be
+		f, err := os.Open(resp.BodyFile)
+		if err != nil {
+			return resp, fmt.Errorf("Error reading body file: %s", err.Error())
+		}
+		f.Close()
+		resp.Msg, resp.Bytes, resp.BodySize = nil, nil, nil
+	case resp.BodyFile != "":
+		b, err := ioutil.ReadFile(resp.BodyFile)
+		if err != nil {
+			return resp, fmt.Errorf("Error reading body file: %s", err.Error())
+		}
+		resp.Msg, resp.Bytes, resp.BodySize = nil, nil, nil
+		if utf8.Valid(b) {
+			msg := string(b)
+			resp.Msg = &msg
+		} else {
+			resp.Bytes = b
+		}
+	case resp.BodyFileLive:
+		return resp, fmt.Errorf("bodyFileLive needs a bodyFile")
+	default:
+		bodies := 0
+		for _, set := range []bool{resp.Msg != nil, resp.Bytes != nil, resp.BodySize != nil} {
+			if set {
+				bodies++
+			}
+		}
+		if bodies == 0 {
+			return resp, fmt.Errorf("message is empty")
+		}
+		if bodies > 1 {
+			return resp, fmt.Errorf("only one of message, bodyBase64 and bodySize may be set")
+		}
+		if resp.BodySize != nil && *resp.BodySize < 0 {
+			return resp, fmt.Errorf("bodySize must not be negative")
+		}
 	}
-	if m.Server != nil {
-		fmt.Println("\nClosing server")
-		err := (*m.Listener).Close()
+	if resp.Status == nil {
+		status := 200
+		resp.Status = &status
+	}
+	resp.tmpl = nil
+	if resp.Template {
+		if resp.Msg == nil {
+			return resp, fmt.Errorf("template needs a text message")
+		}
+		tmpl, err := parseTemplate(*resp.Msg)
 		if err != nil {
-			return err
+			return resp, fmt.Errorf("invalid template: %s", err.Error())
 		}
-		m.Listener = nil
+		resp.tmpl = tmpl
 	}
-	return nil
+	return resp, nil
 }
 
-// AddPathMsg add message to a URL path
-func (m *Mutux) AddPathMsg(path, msg string) {
+// DelPathMsg delete msg from a URL path. If path has query params, only the message
+// added with exactly those params is deleted; otherwise all messages on path are.
+func (m *Mutux) DelPathMsg(path string) {
 	if m == nil {
 		return
 	}
-	status := 200
-	// Need to strip preceding "/", as well as any URL parameters.
-	// Support for URL params will be added later.
-	i := 0
-	pathlen := len(path)
-	for i < pathlen && path[i] == '/' {
-		i++
-	}
-	if i > 0 {
-		path = path[i:pathlen]
-	}
-	path = strings.Split(path, "?")[0]
-	fmt.Println(fmt.Sprintf("adding path /%s", path))
-	m.Pathmsg[path] = Message{
-		Msg:    &msg,
-		Status: &status,
+	if strings.Contains(path, "?") {
+		match, err := queryMatch(path)
+		if err != nil {
+			logf(LogError, "Failed to delete path: %s\n", err.Error())
+			return
+		}
+		m.store.delMatch(trimPath(path), match)
+		return
 	}
+	m.store.delMsg(trimPath(path))
 }
 
-// AddPathMsgAndStatus add message to a URL path, with specified status code
-func (m *Mutux) AddPathMsgAndStatus(path, msg string, status int) {
+// DelMethodPathMsg delete all messages on a URL path added for method
+func (m *Mutux) DelMethodPathMsg(method, path string) {
 	if m == nil {
 		return
 	}
-	// Need to strip preceding "/", as well as any URL parameters.
-	// Support for URL params will be added later.
-	i := 0
-	pathlen := len(path)
-	for i < pathlen && path[i] == '/' {
-		i++
+	path = trimPath(path)
+	for _, stub := range m.store.stubsAt(path) {
+		if stub.Method == strings.ToUpper(method) {
+			m.store.delID(stub.ID)
+		}
 	}
-	if i > 0 {
-		path = path[i:pathlen]
+}
+
+// DelStub delete the stub with id, returning whether it existed
+func (m *Mutux) DelStub(id string) bool {
+	if m == nil {
+		return false
 	}
-	path = strings.Split(path, "?")[0]
-	fmt.Println(fmt.Sprintf("adding path /%s with status %d", path, status))
-	m.Pathmsg[path] = Message{
-		Msg:    &msg,
-		Status: &status,
+	return m.store.delID(id)
+}
+
+// ResetSequence rewind the response sequence of the stub with id to its first response, returning whether the stub exists
+func (m *Mutux) ResetSequence(id string) bool {
+	if m == nil {
+		return false
 	}
+	return m.store.resetPosition(id)
 }
 
-// DelPathMsg delete msg from a URL path
-func (m *Mutux) DelPathMsg(path string) {
+// ResetSequences rewind the response sequences of all stubs to their first response
+func (m *Mutux) ResetSequences() {
 	if m == nil {
 		return
 	}
-	delete(m.Pathmsg, path)
+	m.store.resetPosition("")
 }
 
-// AddHeader add header to all GET and POST responses
+// Stubs return a snapshot of all stubs, ordered by path
+func (m *Mutux) Stubs() []Stub {
+	if m == nil {
+		return nil
+	}
+	return m.store.stubs()
+}
+
+// Pathmsg return a snapshot of all path messages
+func (m *Mutux) Pathmsg() map[string][]Message {
+	if m == nil {
+		return nil
+	}
+	return m.store.msgs()
+}
+
+// AddHeader add header to all stub responses
 func (m *Mutux) AddHeader(name, value string) {
 	if m == nil {
 		return
 	}
-	m.Headers[name] = value
+	m.store.setHeader(name, value)
 }
 
-// DelHeader delete header from all GET and POST responses
+// DelHeader delete header from all stub responses
 func (m *Mutux) DelHeader(name string) {
 	if m == nil {
 		return
 	}
-	delete(m.Headers, name)
+	m.store.delHeader(name)
+}
+
+// Headers return a snapshot of headers added to all stub responses
+func (m *Mutux) Headers() map[string]string {
+	if m == nil {
+		return nil
+	}
+	return m.store.headerCopy()
 }
 
 // EnablePUT enable modifying path message by PUT
@@ -196,7 +439,7 @@ func (m *Mutux) EnablePUT() {
 	if m == nil {
 		return
 	}
-	*m.AllowPUT = true
+	m.store.setAllowPUT(true)
 }
 
 // DisablePUT disable modifying path message by PUT
@@ -204,237 +447,439 @@ func (m *Mutux) DisablePUT() {
 	if m == nil {
 		return
 	}
-	*m.AllowPUT = false
+	m.store.setAllowPUT(false)
+}
+
+// SetReadOnly refuse, or allow again, changes through the HTTP API: admin requests other than GET
+// are answered 403, and PUT to stubbed paths is answered as other methods. The Go API is not affected.
+func (m *Mutux) SetReadOnly(readOnly bool) {
+	if m == nil {
+		return
+	}
+	m.store.setReadOnly(readOnly)
+}
+
+// ReadOnly return whether changes through the HTTP API are refused
+func (m *Mutux) ReadOnly() bool {
+	if m == nil {
+		return false
+	}
+	return m.store.isReadOnly()
+}
+
+// SetFileRoot allow stubs and mounts added through the HTTP API to read body files and serve directories
+// within dir, relative names resolved against it; an empty dir, the default, allows none. Stubs and
+// mounts added from Go or config files may read any file.
+func (m *Mutux) SetFileRoot(dir string) error {
+	if m == nil {
+		return nil
+	}
+	if dir == "" {
+		m.store.setFileRoot("")
+		return nil
+	}
+	root, err := filepath.Abs(dir)
+	if err == nil {
+		root, err = filepath.EvalSymlinks(root)
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to set file root: %s", err.Error())
+	}
+	info, err := os.Stat(root)
+	if err != nil {
+		return fmt.Errorf("Failed to set file root: %s", err.Error())
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("Failed to set file root: %s is not a directory", dir)
+	}
+	m.store.setFileRoot(root)
+	return nil
+}
+
+// FileRoot return the directory stubs and mounts added through the HTTP API may read files from; empty if none
+func (m *Mutux) FileRoot() string {
+	if m == nil {
+		return ""
+	}
+	return m.store.filesRoot()
+}
+
+// Reset delete all path messages, expected orders and journal entries, and restore default headers and PUT setting.
+// User-defined handler funcs and mounts, the read-only setting and the file root are kept.
+func (m *Mutux) Reset() {
+	if m == nil {
+		return
+	}
+	m.store.reset()
+	m.journal.reset()
 }
 
-// AddHandlerFunc add user-defined handler func to path
+// ResetAll restore the server to its state when created, as Reset does, and also stop watching
+// stub directories, delete handler funcs and mounts, and restore the journal size, random seed,
+// admin prefix, read-only setting and file root.
+// The address and TLS files are kept.
+func (m *Mutux) ResetAll() {
+	if m == nil {
+		return
+	}
+	m.StopWatching()
+	m.Reset()
+	m.ClearHandlerFunc()
+	m.mounts.reset()
+	m.SetJournalSize(DefaultJournalSize)
+	m.faults.seed(time.Now().UnixNano())
+	m.SetAdminPrefix(AdminPrefix)
+	m.SetReadOnly(false)
+	m.SetFileRoot("")
+}
+
+// PUTAllowed return whether modifying path message by PUT is enabled
+func (m *Mutux) PUTAllowed() bool {
+	if m == nil {
+		return false
+	}
+	return m.store.putAllowed()
+}
+
+// AddHandlerFunc add user-defined handler func to path; the change takes effect immediately
 func (m *Mutux) AddHandlerFunc(route string, f *func(w http.ResponseWriter, r *http.Request), methods []string) {
 	if m == nil {
 		return
 	}
+	m.handlerMu.Lock()
+	defer m.handlerMu.Unlock()
 	// Add to func array
 	m.CustomHandlerfuncs = append(m.CustomHandlerfuncs, Handlerfunc{
 		Route:    route,
 		Function: f,
 		Methods:  methods,
 	})
+	m.reloadRouter()
 }
 
-// AddHandlerFuncAndRestart add user-defined handler func to path, and restart the server for change to take effect
+// AddHandlerFuncAndRestart add user-defined handler func to path.
+//
+// Deprecated: AddHandlerFunc takes effect without restart.
 func (m *Mutux) AddHandlerFuncAndRestart(route string, f *func(w http.ResponseWriter, r *http.Request), methods []string) error {
+	m.AddHandlerFunc(route, f, methods)
+	return nil
+}
+
+// DelHandlerFunc delete all user-defined handler funcs added to route; the change takes effect immediately
+func (m *Mutux) DelHandlerFunc(route string) {
 	if m == nil {
-		return nil
+		return
 	}
-	// Add to func array
-	m.CustomHandlerfuncs = append(m.CustomHandlerfuncs, Handlerfunc{
-		Route:    route,
-		Function: f,
-		Methods:  methods,
-	})
-	err := m.Restart()
-	if err != nil {
-		return fmt.Errorf("Failed to add handler function: %s", err.Error())
+	m.handlerMu.Lock()
+	defer m.handlerMu.Unlock()
+	kept := []Handlerfunc{}
+	for _, h := range m.CustomHandlerfuncs {
+		if h.Route != route {
+			kept = append(kept, h)
+		}
 	}
-	return nil
+	m.CustomHandlerfuncs = kept
+	m.reloadRouter()
 }
 
-// ClearHandlerFunc delete all user-defined handler funcs, and restart the server for change to take effect
+// ClearHandlerFunc delete all user-defined handler funcs; the change takes effect immediately
 func (m *Mutux) ClearHandlerFunc() {
 	if m == nil {
 		return
 	}
+	m.handlerMu.Lock()
+	defer m.handlerMu.Unlock()
 	// delete func array
 	m.CustomHandlerfuncs = nil
+	m.reloadRouter()
 }
 
-// ClearHandlerFuncAndRestart delete all user-defined handler funcs, and restart the server for change to take effect
+// ClearHandlerFuncAndRestart delete all user-defined handler funcs.
+//
+// Deprecated: ClearHandlerFunc takes effect without restart.
 func (m *Mutux) ClearHandlerFuncAndRestart() error {
+	m.ClearHandlerFunc()
+	return nil
+}
+
+// boundAddr addr with port 0 replaced by the port listener was bound to, keeping the host as given
+func boundAddr(addr string, listener net.Listener) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || (port != "0" && port != "") {
+		return addr
+	}
+	_, bound, err := net.SplitHostPort(listener.Addr().String())
+	if err != nil {
+		return addr
+	}
+	return net.JoinHostPort(host, bound)
+}
+
+// Addr return the address the server is bound to
+func (m *Mutux) Addr() string {
 	if m == nil {
-		return nil
+		return ""
 	}
-	// delete func array
-	m.CustomHandlerfuncs = nil
-	err := m.Restart()
+	return m.Address
+}
+
+// URL return the base URL of the server, e.g. "http://127.0.0.1:41234", using a loopback
+// address if the server listens on all interfaces
+func (m *Mutux) URL() string {
+	if m == nil {
+		return ""
+	}
+	scheme := "http"
+	if m.Certfile != "" && m.Keyfile != "" {
+		scheme = "https"
+	}
+	host, port, err := net.SplitHostPort(m.Address)
 	if err != nil {
-		return fmt.Errorf("Failed to clear handler functions: %s", err.Error())
+		return scheme + "://" + m.Address
 	}
-	return nil
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "127.0.0.1"
+		if ip != nil && ip.To4() == nil {
+			host = "::1"
+		}
+	}
+	return scheme + "://" + net.JoinHostPort(host, port)
 }
 
-// Restart restart server (to load newly added functions to server host, for example)
-func (m *Mutux) Restart() error {
+// SetAdminPrefix serve the admin endpoints under prefix instead of AdminPrefix, e.g. when stubbed
+// paths would clash with it; the change takes effect immediately
+func (m *Mutux) SetAdminPrefix(prefix string) error {
 	if m == nil {
 		return nil
 	}
-	// recreate router
-	r := mux.NewRouter()
+	prefix = "/" + strings.Trim(prefix, "/")
+	if prefix == "/" {
+		return fmt.Errorf("admin prefix must not be empty")
+	}
+	m.handlerMu.Lock()
+	defer m.handlerMu.Unlock()
+	m.adminPrefix = prefix
+	m.adminfuncs = adminFuncs(prefix, m.store, m.journal, m.mounts)
+	m.journal.setPrefix(prefix)
+	m.reloadRouter()
+	return nil
+}
 
-	m.addHandlersToRouter(r)
+// AdminPrefix return the path prefix the admin endpoints are served under
+func (m *Mutux) AdminPrefix() string {
+	if m == nil {
+		return ""
+	}
+	m.handlerMu.Lock()
+	defer m.handlerMu.Unlock()
+	return m.adminPrefix
+}
 
-	err := m.Stop()
-	if err != nil {
-		return fmt.Errorf("Failed to remake router: %s", err.Error())
+// Restart restart server by closing and rebinding its listener, and rebuild its router from CustomHandlerfuncs.
+// Handler changes made with AddHandlerFunc and DelHandlerFunc no longer need a restart to take effect.
+func (m *Mutux) Restart() error {
+	if m == nil {
+		return nil
 	}
-	m.Server = &http.Server{}
-	m.Server.Addr = m.Address
-	m.Server.Handler = r
+	r := m.lifecycle.stop(false)
+	m.lifecycle.serverMu.Lock()
+	err := m.closeListener()
+	m.lifecycle.serverMu.Unlock()
+	if err != nil {
+		return fmt.Errorf("Failed to restart server: %s", err.Error())
+	}
+	m.lifecycle.wait(r)
+	// pick up handler funcs added to CustomHandlerfuncs directly
+	m.handlerMu.Lock()
+	m.reloadRouter()
+	m.handlerMu.Unlock()
+	m.lifecycle.serverMu.Lock()
+	m.Server = &http.Server{Addr: m.Address, Handler: m.handler}
+	m.lifecycle.serverMu.Unlock()
 	err = m.Start()
 	if err != nil {
-		return fmt.Errorf("Failed to remake router: %s", err.Error())
+		return fmt.Errorf("Failed to restart server: %s", err.Error())
 	}
 	return nil
 }
 
+// reloadRouter rebuild router from handler funcs and swap it in; caller must hold handlerMu
+func (m *Mutux) reloadRouter() {
+	r := mux.NewRouter()
+	r.NotFoundHandler = http.HandlerFunc(*m.unmatchedfunc)
+	m.addHandlersToRouter(r)
+	m.Handler = r
+	m.router.swap(r)
+}
+
 func (m *Mutux) addHandlersToRouter(r *mux.Router) {
+	// add admin funcs first so that nothing can shadow the admin prefix
+	for _, h := range m.adminfuncs {
+		addHandlerfuncToRouter(r, h)
+	}
 	// add custom funcs to router; they are added before the original funcs because otherwise the original funcs would override the custom funcs
 	// add custom funcs in reverse order so that newly added funcs have higher processing priority
 	for i := len(m.CustomHandlerfuncs) - 1; i >= 0; i-- {
-		h := m.CustomHandlerfuncs[i]
-		if h.Methods != nil {
-			for _, m := range h.Methods {
-				r.HandleFunc(h.Route, *h.Function).Methods(m)
-			}
-		} else {
-			r.HandleFunc(h.Route, *h.Function)
-		}
+		addHandlerfuncToRouter(r, m.CustomHandlerfuncs[i])
 	}
-	// add back original message funcs to router
+	// add back original message funcs to router; requests no stub matches fall through to the mounts,
+	// then to the unmatched response
 	for _, h := range m.handlerfuncs {
-		if h.Methods != nil {
-			for _, m := range h.Methods {
-				r.HandleFunc(h.Route, *h.Function).Methods(m)
-			}
-		} else {
-			r.HandleFunc(h.Route, *h.Function)
+		addHandlerfuncToRouter(r, h)
+	}
+}
+
+func addHandlerfuncToRouter(r *mux.Router, h Handlerfunc) {
+	if h.Methods != nil {
+		for _, m := range h.Methods {
+			r.HandleFunc(h.Route, *h.Function).Methods(m)
 		}
+	} else {
+		r.HandleFunc(h.Route, *h.Function)
 	}
 }
 
-//NewMutux creates a new instance of Mutux server with port number specified
+// NewMutux creates a new instance of Mutux server with port number specified; with port 0 a free port is picked,
+// which URL and Address then report
 func NewMutux(port int) (*Mutux, error) {
 	return NewMutuxWithAddr(fmt.Sprintf(":%d", port))
 }
 
-//NewMutuxWithAddr creates a new instance of Mutux server with string address specified
+// NewMutuxWithAddr creates a new instance of Mutux server with string address specified.
+// With port 0 a free port is picked, and Address is set to it so that restarts keep it.
 func NewMutuxWithAddr(addr string) (*Mutux, error) {
-	pathmsg := map[string]Message{}
-	headers := map[string]string{
-		"Content-type": "application/json",
-	}
-	allowPUT := true
-	r := mux.NewRouter()
-
-	GETmessagefunc := func(w http.ResponseWriter, r *http.Request) {
-		vars := mux.Vars(r)
-		name := vars["name"]
-		msg, exists := pathmsg[name]
+	store := newStubStore()
+	journal := newJournal()
+	faults := newFaultRand()
+	mounts := newMounts()
+	lifecycle := newLifecycle()
+
+	unmatchedfunc := unmatchedFunc(store, faults)
+	messagefunc := func(w http.ResponseWriter, r *http.Request) {
+		name := mux.Vars(r)["name"]
+		req := newMatchRequest(r)
+		stub, vars, exists := store.match(name, req)
 		if !exists {
-			http.Error(w, "404 page not found", 404)
+			if redirectTrailingSlash(w, r, store, name, req) || mounts.serve(w, r, name, store.pathOptions()) {
+				return
+			}
+			unmatchedfunc(w, r)
 			return
 		}
-		w.WriteHeader(*msg.Status)
-		for k, v := range headers {
-			w.Header().Set(k, v)
-		}
-		fmt.Printf("\nIn GET message handler, returning: \n%s\n", *msg.Msg)
-		fmt.Fprintf(w, *msg.Msg)
-	}
-	POSTmessagefunc := func(w http.ResponseWriter, r *http.Request) {
-		vars := mux.Vars(r)
-		name := vars["name"]
-		msg, exists := pathmsg[name]
+		setMatchedStub(r, stub)
+		store.addCall(stub.ID)
+		resp, exists := store.nextResponse(stub.ID)
 		if !exists {
-			http.Error(w, `{"error":"404 page not found"}`, 404)
+			writeError(w, http.StatusNotFound, "404 page not found: response sequence exhausted")
+			return
+		}
+		resp, err := renderResponse(resp, req, vars, store, faults)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
 			return
 		}
-		w.WriteHeader(*msg.Status)
-		for k, v := range headers {
-			w.Header().Set(k, v)
+		if !faults.wait(r, stub.Delay) || faults.inject(w, r, stub.Fault, resp, store.headerCopy(), lifecycle.closing()) {
+			return
 		}
-		fmt.Printf("\nIn POST message handler, returning: \n%s\n", *msg.Msg)
-		fmt.Fprintf(w, *msg.Msg)
+		writeResponse(w, r, resp, store.headerCopy())
 	}
 	PUTmessagefunc := func(w http.ResponseWriter, r *http.Request) {
-		if !allowPUT {
+		if !store.putAllowed() || store.isReadOnly() {
+			// stub namespace is read-only; answer PUT like any other method
+			messagefunc(w, r)
 			return
 		}
 		vars := mux.Vars(r)
 		name := vars["name"]
+		// an explicit PUT stub answers the request instead of being replaced by it
+		if stub, _, exists := store.match(name, newMatchRequest(r)); exists && stub.Method == http.MethodPut {
+			messagefunc(w, r)
+			return
+		}
 		body, err := ioutil.ReadAll(r.Body)
 		if err != nil {
-			http.Error(w, fmt.Sprintf("Error reading body: %s", err.Error()), 500)
+			writeError(w, http.StatusInternalServerError, fmt.Sprintf("Error reading body: %s", err.Error()))
 			return
 		}
 		putmsg := Message{}
 		err = json.Unmarshal(body, &putmsg)
 		if err != nil {
-			http.Error(w, fmt.Sprintf("Error unmarshalling body: %s", err.Error()), 500)
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("Error unmarshalling body: %s", err.Error()))
 			return
 		}
-		if putmsg.Msg == nil {
-			http.Error(w, fmt.Sprintf("Error: message is empty"), 500)
+		putmsg, err = remoteMessage(store.filesRoot(), putmsg)
+		if err != nil {
+			writeError(w, http.StatusForbidden, fmt.Sprintf("Error: %s", err.Error()))
 			return
 		}
-		if putmsg.Status == nil {
-			status := 200
-			putmsg.Status = &status
+		name, putmsg, err = prepareStub(name, putmsg)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("Error: %s", err.Error()))
+			return
 		}
-		fmt.Println("adding path: /" + name)
-		pathmsg[name] = putmsg
+		logf(LogInfo, "adding path: /%s\n", name)
+		store.setMsg(name, putmsg)
 		fmt.Fprintf(w, "success")
 	}
 	CORSfunc := func(w http.ResponseWriter, r *http.Request) {
+		if stub, _, exists := store.match(mux.Vars(r)["name"], newMatchRequest(r)); exists && stub.Method == http.MethodOptions {
+			messagefunc(w, r)
+			return
+		}
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, HEAD")
 	}
 
 	handlerfuncs := []Handlerfunc{
-		// GET handler returns message for any URL path
+		// OPTIONS handler handles browser CORS preflight, unless an OPTIONS message is added
 		Handlerfunc{
-			Route:    `/{name:[a-zA-Z0-9=\-\/]*}`,
-			Function: &GETmessagefunc,
-			Methods:  []string{"GET"},
-		},
-		// POST handler returns message for any URL path
-		Handlerfunc{
-			Route:    `/{name:[a-zA-Z0-9=\-\/]*}`,
-			Function: &POSTmessagefunc,
-			Methods:  []string{"POST"},
+			Route:    stubRoute,
+			Function: &CORSfunc,
+			Methods:  []string{"OPTIONS"},
 		},
-		// PUT handler updates message for any URL path
+		// PUT handler updates message for any URL path, if PUT is allowed, unless a PUT message is added
 		Handlerfunc{
-			Route:    `/{name:[a-zA-Z0-9=\-\/]*}`,
+			Route:    stubRoute,
 			Function: &PUTmessagefunc,
 			Methods:  []string{"PUT"},
 		},
-		// OPTIONS handler handles browser CORS preflight
+		// message handler returns message for any URL path, whatever the method
 		Handlerfunc{
-			Route:    `/{name:[a-zA-Z0-9=\-\/]*}`,
-			Function: &CORSfunc,
-			Methods:  []string{"OPTIONS"},
+			Route:    stubRoute,
+			Function: &messagefunc,
 		},
 	}
 
-	server := &http.Server{}
-	server.Addr = addr
-	server.Handler = r
 	listener, err := net.Listen("tcp", addr)
 	if err != nil {
 		return nil, err
 	}
+	configAddr := addr
+	addr = boundAddr(addr, listener)
+	handler := &routerSwitch{}
+	server := &http.Server{}
+	server.Addr = addr
+	server.Handler = journal.record(handler)
 
 	mutux := Mutux{
-		Address:      addr,
-		Server:       server,
-		Listener:     &listener,
-		Pathmsg:      pathmsg,
-		Headers:      headers,
-		AllowPUT:     &allowPUT,
-		Handler:      r,
-		handlerfuncs: handlerfuncs,
-	}
-
-	mutux.addHandlersToRouter(r)
+		Address:       addr,
+		configAddr:    configAddr,
+		Server:        server,
+		Listener:      &listener,
+		handler:       server.Handler,
+		handlerfuncs:  handlerfuncs,
+		adminfuncs:    adminFuncs(AdminPrefix, store, journal, mounts),
+		unmatchedfunc: &unmatchedfunc,
+		adminPrefix:   AdminPrefix,
+		router:        handler,
+		store:         store,
+		journal:       journal,
+		faults:        faults,
+		mounts:        mounts,
+		lifecycle:     lifecycle,
+	}
+
+	mutux.reloadRouter()
 
 	return &mutux, nil
 }
@@ -0,0 +1,123 @@
+package mutux
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// quiet turn logging off until the test ends, then restore the level it had
+func quiet(t testing.TB) {
+	level := LogLevel(atomic.LoadInt32(&logLevel))
+	SetLogLevel(LogOff)
+	t.Cleanup(func() {
+		SetLogLevel(level)
+	})
+}
+
+// newTestServer start a quiet server on a free loopback port, stopped when the test ends
+func newTestServer(t testing.TB) *Mutux {
+	t.Helper()
+	quiet(t)
+	m, err := NewMutuxWithAddr("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		// a connection the client dialed but did not use would hold Stop until it times out
+		http.DefaultClient.CloseIdleConnections()
+		m.Stop()
+	})
+	<-m.Ready()
+	return m
+}
+
+// TestConcurrentStubs exercise concurrent PUTs, programmatic adds and deletes, header changes and GETs;
+// run with go test -race
+func TestConcurrentStubs(t *testing.T) {
+	m := newTestServer(t)
+	base := m.URL()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(5)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				m.AddPathMsg(fmt.Sprintf("go/%d/%d", i, j), "added")
+				m.DelPathMsg(fmt.Sprintf("go/%d/%d", i, j-1))
+			}
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				m.AddHeader(fmt.Sprintf("X-Header-%d", i), fmt.Sprint(j))
+				m.Headers()
+				m.Stubs()
+			}
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				body := strings.NewReader(`{"message":"put","status":201}`)
+				req, err := http.NewRequest(http.MethodPut, fmt.Sprintf("%s/put/%d/%d", base, i, j), body)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				resp, err := http.DefaultClient.Do(req)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				resp.Body.Close()
+			}
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				resp, err := http.Get(fmt.Sprintf("%s/put/%d/%d", base, i, j))
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				ioutil.ReadAll(resp.Body)
+				resp.Body.Close()
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				m.Requests()
+				m.PUTAllowed()
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i := 0; i < 8; i++ {
+		resp, err := http.Get(fmt.Sprintf("%s/put/%d/19", base, i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusCreated || string(b) != "put" {
+			t.Errorf("GET /put/%d/19 = %d %q, want 201 \"put\"", i, resp.StatusCode, b)
+		}
+		if len(m.store.stubsAt(fmt.Sprintf("go/%d/19", i))) != 1 {
+			t.Errorf("stub go/%d/19 missing", i)
+		}
+		if len(m.store.stubsAt(fmt.Sprintf("go/%d/18", i))) != 0 {
+			t.Errorf("stub go/%d/18 not deleted", i)
+		}
+	}
+}
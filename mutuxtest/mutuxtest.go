@@ -0,0 +1,191 @@
+// Package mutuxtest runs Mutux servers for the duration of a test.
+//
+//	func TestHello(t *testing.T) {
+//		m := mutuxtest.New(t, mutuxtest.FailOnUnmatched())
+//		m.AddPathMsg("hello", "Hello, world!")
+//		http.Get(m.URL() + "/hello")
+//		m.AssertCalled(t, "GET", "/hello", 1)
+//	}
+package mutuxtest
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/dzhoou/mutux"
+)
+
+// Server Mutux server started for a test, and stopped when the test ends
+type Server struct {
+	*mutux.Mutux
+}
+
+type options struct {
+	failOnUnmatched bool
+	config          *mutux.Config
+	configFile      string
+}
+
+// Option configures a server created by New
+type Option func(*options)
+
+// FailOnUnmatched fail the test when it ends if the server received requests that no stub or handler func answered
+func FailOnUnmatched() Option {
+	return func(o *options) {
+		o.failOnUnmatched = true
+	}
+}
+
+// WithConfig apply cfg to the server before it starts; its address is ignored
+func WithConfig(cfg mutux.Config) Option {
+	return func(o *options) {
+		o.config = &cfg
+	}
+}
+
+// WithConfigFile apply the JSON or YAML config file to the server before it starts; its address is ignored
+func WithConfigFile(file string) Option {
+	return func(o *options) {
+		o.configFile = file
+	}
+}
+
+// New start a Mutux server on a free loopback port, stopped when the test and its subtests end.
+// Failing to create or start it fails the test immediately.
+func New(t testing.TB, opts ...Option) *Server {
+	t.Helper()
+	o := options{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	m, err := mutux.NewMutuxWithAddr("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("mutuxtest: Failed to create server: %s", err.Error())
+	}
+	t.Cleanup(func() {
+		m.Stop()
+	})
+	if o.configFile != "" {
+		cfg, err := mutux.ReadConfig(o.configFile)
+		if err != nil {
+			t.Fatalf("mutuxtest: %s", err.Error())
+		}
+		o.config = &cfg
+	}
+	if o.config != nil {
+		err = m.ApplyConfig(*o.config)
+		if err != nil {
+			t.Fatalf("mutuxtest: Failed to apply config: %s", err.Error())
+		}
+	}
+	err = m.Start()
+	if err != nil {
+		t.Fatalf("mutuxtest: Failed to start server: %s", err.Error())
+	}
+	<-m.Ready()
+	s := &Server{Mutux: m}
+	// cleanups run last in first out, so these run before the server is stopped
+	t.Cleanup(func() {
+		s.AssertExpectations(t)
+	})
+	if o.failOnUnmatched {
+		t.Cleanup(func() {
+			s.AssertNoUnmatched(t)
+		})
+	}
+	return s
+}
+
+// AssertCalled check that the server received exactly times requests with method and path;
+// empty method matches any. On failure the requests received on path are listed.
+func (s *Server) AssertCalled(t testing.TB, method, path string, times int) bool {
+	t.Helper()
+	path = "/" + strings.TrimLeft(path, "/")
+	calls := s.FindRequests(method, path)
+	if len(calls) == times {
+		return true
+	}
+	t.Errorf("mutuxtest: expected %d %s, got %d\n%s", times, describe(method, path), len(calls), listRequests(s.FindRequests("", path)))
+	return false
+}
+
+// AssertNotCalled check that the server received no request with method and path; empty method matches any
+func (s *Server) AssertNotCalled(t testing.TB, method, path string) bool {
+	t.Helper()
+	return s.AssertCalled(t, method, path, 0)
+}
+
+// AssertStubCalled check that the stub with id answered exactly times requests, however many the journal keeps
+func (s *Server) AssertStubCalled(t testing.TB, id string, times int) bool {
+	t.Helper()
+	calls := s.StubCalls(id)
+	if calls == times {
+		return true
+	}
+	path := ""
+	for _, stub := range s.Stubs() {
+		if stub.ID == id {
+			path = stub.Path
+		}
+	}
+	if path == "" {
+		t.Errorf("mutuxtest: expected stub %s to answer %d requests, but there is no stub %s", id, times, id)
+		return false
+	}
+	t.Errorf("mutuxtest: expected stub %s on %s to answer %d requests, got %d\n%s", id, path, times, calls, listRequests(s.FindRequests("", path)))
+	return false
+}
+
+// AssertNoUnmatched check that every request received was answered by a stub or handler func
+func (s *Server) AssertNoUnmatched(t testing.TB) bool {
+	t.Helper()
+	unmatched := s.UnmatchedRequests()
+	if len(unmatched) == 0 {
+		return true
+	}
+	t.Errorf("mutuxtest: %d requests matched no stub\n%s", len(unmatched), listRequests(unmatched))
+	return false
+}
+
+// AssertExpectations check the expectations declared on stubs and with ExpectOrder; New checks them
+// when the test ends, so calling it is only needed to check them earlier
+func (s *Server) AssertExpectations(t testing.TB) bool {
+	t.Helper()
+	report := s.Verify()
+	if report.OK() {
+		return true
+	}
+	t.Errorf("mutuxtest: expectations not met\n%s", report.String())
+	return false
+}
+
+func describe(method, path string) string {
+	if method == "" {
+		return "requests to " + path
+	}
+	return fmt.Sprintf("requests %s %s", strings.ToUpper(method), path)
+}
+
+// listRequests one line per request, followed by the stubs closest to it if it was unmatched,
+// or a note that there are none
+func listRequests(entries []mutux.JournalEntry) string {
+	if len(entries) == 0 {
+		return "\tno requests received"
+	}
+	lines := make([]string, len(entries))
+	for i, e := range entries {
+		target := e.Path
+		if len(e.Query) > 0 {
+			target += "?" + e.Query.Encode()
+		}
+		lines[i] = fmt.Sprintf("\t%s %s -> %d", e.Method, target, e.Status)
+		if e.StubID != "" {
+			lines[i] += " (stub " + e.StubID + ")"
+		}
+		for _, miss := range e.Closest {
+			lines[i] += fmt.Sprintf("\n\t\tclosest stub %s (%s): %s", miss.ID, miss.Path, strings.Join(miss.Reasons, "; "))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,94 @@
+package mutuxtest
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/dzhoou/mutux"
+)
+
+// fakeT records the failures of assertions instead of failing the test
+type fakeT struct {
+	testing.TB
+	errors []string
+}
+
+func (t *fakeT) Helper() {}
+
+func (t *fakeT) Errorf(format string, args ...interface{}) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+func get(t *testing.T, s *Server, path string) {
+	t.Helper()
+	resp, err := http.Get(s.URL() + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+}
+
+func TestAssertions(t *testing.T) {
+	mutux.SetLogLevel(mutux.LogOff)
+	defer mutux.SetLogLevel(mutux.LogDebug)
+	s := New(t)
+	defer http.DefaultClient.CloseIdleConnections()
+	ok := "ok"
+	stub, err := s.AddStub("hello", mutux.Message{Response: mutux.Response{Msg: &ok}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	get(t, s, "/hello")
+	get(t, s, "/hello")
+	get(t, s, "/missing")
+	// stub calls are counted apart from the journal
+	s.ResetJournal()
+	get(t, s, "/missing")
+
+	for _, c := range []struct {
+		name   string
+		assert func(t testing.TB) bool
+		failed string
+	}{
+		{"called", func(t testing.TB) bool { return s.AssertCalled(t, "GET", "missing", 1) }, ""},
+		{"not called", func(t testing.TB) bool { return s.AssertNotCalled(t, "", "/hello") }, ""},
+		{"called twice", func(t testing.TB) bool { return s.AssertCalled(t, "", "/missing", 2) }, "expected 2 requests to /missing, got 1"},
+		{"stub called", func(t testing.TB) bool { return s.AssertStubCalled(t, stub.ID, 2) }, ""},
+		{"stub called once", func(t testing.TB) bool { return s.AssertStubCalled(t, stub.ID, 1) }, "to answer 1 requests, got 2"},
+		{"no stub", func(t testing.TB) bool { return s.AssertStubCalled(t, "none", 1) }, "there is no stub none"},
+		{"unmatched", func(t testing.TB) bool { return s.AssertNoUnmatched(t) }, "1 requests matched no stub"},
+	} {
+		ft := &fakeT{TB: t}
+		passed := c.assert(ft)
+		switch {
+		case c.failed == "" && (!passed || len(ft.errors) > 0):
+			t.Errorf("%s: failed with %v, want it to pass", c.name, ft.errors)
+		case c.failed != "" && (passed || len(ft.errors) != 1 || !strings.Contains(ft.errors[0], c.failed)):
+			t.Errorf("%s: returned %v with %v, want one failure containing %q", c.name, passed, ft.errors, c.failed)
+		}
+	}
+}
+
+func TestAssertExpectations(t *testing.T) {
+	mutux.SetLogLevel(mutux.LogOff)
+	defer mutux.SetLogLevel(mutux.LogDebug)
+	s := New(t)
+	defer http.DefaultClient.CloseIdleConnections()
+	ok := "ok"
+	_, err := s.AddStub("once", mutux.Message{Response: mutux.Response{Msg: &ok}, Expect: mutux.ExpectTimes(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ft := &fakeT{TB: t}
+	if s.AssertExpectations(ft) || len(ft.errors) != 1 || !strings.Contains(ft.errors[0], "expectations not met") {
+		t.Errorf("AssertExpectations before the call reported %v, want one failure", ft.errors)
+	}
+	get(t, s, "/once")
+	ft = &fakeT{TB: t}
+	if !s.AssertExpectations(ft) || len(ft.errors) != 0 {
+		t.Errorf("AssertExpectations after the call failed with %v", ft.errors)
+	}
+}
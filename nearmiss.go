@@ -0,0 +1,223 @@
+package mutux
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Weights of how closely a request comes to a stub; the path outweighs everything else
+const (
+	pathWeight      = 10
+	methodWeight    = 3
+	conditionWeight = 2
+)
+
+// compare score how close r, requested at path, comes to matching stub, comparing paths under paths,
+// and list why it does not; no reasons means the stub matches
+func compare(stub Stub, path string, r *matchRequest, paths PathOptions) (int, []string) {
+	score := 0
+	reasons := []string{}
+	stubPath := strings.TrimLeft(stub.Path, "/")
+	switch {
+	case stub.pattern != nil:
+		if _, ok := stub.pattern.match(path); ok {
+			score += pathWeight
+		} else {
+			score += pathScore(paths.fold(stubPath), paths.fold(path))
+			reasons = append(reasons, fmt.Sprintf("path /%s does not match %s", path, stub.Path))
+		}
+	case paths.same(unescapePath(stubPath), path):
+		score += pathWeight
+	default:
+		score += pathScore(paths.fold(stubPath), paths.fold(path))
+		reasons = append(reasons, fmt.Sprintf("path /%s is not %s", path, stub.Path))
+	}
+	if stub.Method != "" {
+		if methodMatches(stub.Method, r.Method) {
+			score += methodWeight
+		} else {
+			reasons = append(reasons, fmt.Sprintf("method %s is not %s", r.Method, stub.Method))
+		}
+	}
+	check := func(kind, name string, v ValueMatcher, values []string) {
+		if ok, _ := v.match(values); ok {
+			score += conditionWeight
+			return
+		}
+		reasons = append(reasons, fmt.Sprintf("%s%s is %s, expected %s", kind, name, describeValues(values), v))
+	}
+	query := r.URL.Query()
+	for _, k := range sortedKeys(stub.Query) {
+		check("query param ", k, stub.Query[k], query[k])
+	}
+	for _, k := range sortedKeys(stub.RequestHeaders) {
+		check("header ", k, stub.RequestHeaders[k], r.Header[http.CanonicalHeaderKey(k)])
+	}
+	for _, k := range sortedKeys(stub.Cookies) {
+		values := []string{}
+		for _, c := range r.Cookies() {
+			if c.Name == k {
+				values = append(values, c.Value)
+			}
+		}
+		check("cookie ", k, stub.Cookies[k], values)
+	}
+	if stub.Body != nil {
+		values := []string{}
+		if len(r.body) > 0 {
+			values = append(values, string(r.body))
+		}
+		check("body", "", *stub.Body, values)
+	}
+	if len(stub.JSONPath) > 0 {
+		doc, err := r.jsonBody()
+		if err != nil {
+			reasons = append(reasons, "body is not JSON")
+		} else {
+			for _, expr := range sortedKeys(stub.JSONPath) {
+				path, _ := parseJSONPath(expr)
+				check("JSONPath ", expr, stub.JSONPath[expr], path.selectStrings(doc))
+			}
+		}
+	}
+	if len(stub.XPath) > 0 {
+		doc, err := r.xmlBody()
+		if err != nil {
+			reasons = append(reasons, "body is not XML")
+		} else {
+			for _, expr := range sortedKeys(stub.XPath) {
+				path, _ := parseXPath(expr)
+				check("XPath ", expr, stub.XPath[expr], path.selectStrings(doc))
+			}
+		}
+	}
+	return score, reasons
+}
+
+// methodMatches return whether a request with method satisfies a stub for stubMethod; a GET stub also answers HEAD
+func methodMatches(stubMethod, method string) bool {
+	return stubMethod == "" || stubMethod == method || (stubMethod == http.MethodGet && method == http.MethodHead)
+}
+
+// pathScore share of pathWeight path earns against stubPath: each segment counts by how similar it is
+// to the one in the same position, so that typos still rank high; variables and wildcards match any segment
+func pathScore(stubPath, path string) int {
+	if stubPath == path {
+		return pathWeight
+	}
+	as, bs := strings.Split(stubPath, "/"), strings.Split(path, "/")
+	common := 0.0
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if isPattern(as[i]) {
+			common++
+		} else {
+			common += similarity(as[i], bs[i])
+		}
+	}
+	return int(pathWeight * common / float64(maxInt(len(as), len(bs))))
+}
+
+// similarity 1 for equal strings down to 0 for entirely different ones, by edit distance
+func similarity(a, b string) float64 {
+	ar, br := []rune(a), []rune(b)
+	longest := maxInt(len(ar), len(br))
+	if longest == 0 {
+		return 1
+	}
+	// Levenshtein distance, keeping one row of the matrix
+	row := make([]int, len(br)+1)
+	for j := range row {
+		row[j] = j
+	}
+	for i := 1; i <= len(ar); i++ {
+		diagonal := row[0]
+		row[0] = i
+		for j := 1; j <= len(br); j++ {
+			cost := 1
+			if ar[i-1] == br[j-1] {
+				cost = 0
+			}
+			next := minInt(minInt(row[j]+1, row[j-1]+1), diagonal+cost)
+			diagonal = row[j]
+			row[j] = next
+		}
+	}
+	return 1 - float64(row[len(br)])/float64(longest)
+}
+
+func sortedKeys(matchers map[string]ValueMatcher) []string {
+	keys := make([]string, 0, len(matchers))
+	for k := range matchers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func describeValues(values []string) string {
+	switch len(values) {
+	case 0:
+		return "absent"
+	case 1:
+		return fmt.Sprintf("%q", values[0])
+	}
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = fmt.Sprintf("%q", v)
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
+
+// String describe the condition, e.g. `equals "1"`
+func (v ValueMatcher) String() string {
+	parts := []string{}
+	if v.Present != nil {
+		if *v.Present {
+			parts = append(parts, "present")
+		} else {
+			parts = append(parts, "absent")
+		}
+	}
+	if v.Equals != nil {
+		parts = append(parts, fmt.Sprintf("equals %q", *v.Equals))
+	}
+	if v.Matches != "" {
+		parts = append(parts, fmt.Sprintf("matches /%s/", v.Matches))
+	}
+	if len(parts) == 0 {
+		return "anything"
+	}
+	return strings.Join(parts, " and ")
+}
+
+// entryRequest rebuild a request from its journal entry, to compare it with stubs
+func entryRequest(e JournalEntry) *matchRequest {
+	r := &http.Request{
+		Method: e.Method,
+		URL:    &url.URL{Path: e.Path, RawQuery: e.Query.Encode()},
+		Header: e.Headers,
+		Body:   ioutil.NopCloser(strings.NewReader(e.Body)),
+	}
+	if r.Header == nil {
+		r.Header = http.Header{}
+	}
+	return &matchRequest{Request: r, body: []byte(e.Body)}
+}
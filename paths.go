@@ -0,0 +1,119 @@
+package mutux
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Trailing slash handling of PathOptions
+const (
+	// TrailingSlashStrict /a and /a/ are different paths
+	TrailingSlashStrict = "strict"
+	// TrailingSlashIgnore /a and /a/ are the same path
+	TrailingSlashIgnore = "ignore"
+	// TrailingSlashRedirect a request for /a/ that only /a has a stub for is redirected there, and the other way round
+	TrailingSlashRedirect = "redirect"
+)
+
+// PathOptions how request paths are compared with stub paths. Paths are always compared
+// percent-decoded, so /a%20b and "/a b" are the same path.
+type PathOptions struct {
+	// TrailingSlash one of TrailingSlashStrict, the default, TrailingSlashIgnore and TrailingSlashRedirect
+	TrailingSlash string `json:"trailingSlash,omitempty"`
+	// CaseInsensitive compare paths regardless of case
+	CaseInsensitive bool `json:"caseInsensitive,omitempty"`
+}
+
+func (o PathOptions) validate() error {
+	switch o.TrailingSlash {
+	case "", TrailingSlashStrict, TrailingSlashIgnore, TrailingSlashRedirect:
+		return nil
+	}
+	return fmt.Errorf("unknown trailing slash handling %q", o.TrailingSlash)
+}
+
+// fold return the form of path, trimmed already, that all paths equal to it under o share
+func (o PathOptions) fold(path string) string {
+	if o.TrailingSlash == TrailingSlashIgnore {
+		path = strings.TrimSuffix(path, "/")
+	}
+	if o.CaseInsensitive {
+		path = strings.ToLower(path)
+	}
+	return path
+}
+
+// same return whether paths a and b, trimmed already, are equal under o
+func (o PathOptions) same(a, b string) bool {
+	return o.fold(a) == o.fold(b)
+}
+
+// trimPath strip preceding "/", as well as any URL parameters, from path, and decode its
+// percent-encoding; a path that is not validly encoded is kept as is
+func trimPath(path string) string {
+	path = strings.Split(strings.TrimLeft(path, "/"), "?")[0]
+	if decoded, err := url.PathUnescape(path); err == nil {
+		return decoded
+	}
+	return path
+}
+
+// toggleTrailingSlash return path, trimmed already, with a trailing slash added or removed;
+// false for the root path, which has no other form
+func toggleTrailingSlash(path string) (string, bool) {
+	if path == "" || path == "/" {
+		return "", false
+	}
+	if strings.HasSuffix(path, "/") {
+		return strings.TrimSuffix(path, "/"), true
+	}
+	return path + "/", true
+}
+
+// redirectTrailingSlash redirect r, requested at path, to the same path with a trailing slash added
+// or removed, if the path options say so and a stub there matches it; return whether it was redirected
+func redirectTrailingSlash(w http.ResponseWriter, r *http.Request, store *stubStore, path string, req *matchRequest) bool {
+	if store.pathOptions().TrailingSlash != TrailingSlashRedirect {
+		return false
+	}
+	other, ok := toggleTrailingSlash(path)
+	if !ok {
+		return false
+	}
+	if _, _, exists := store.match(other, req); !exists {
+		return false
+	}
+	target := *r.URL
+	target.Path = "/" + other
+	target.RawPath = ""
+	status := http.StatusMovedPermanently
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		// keep the method and body
+		status = http.StatusPermanentRedirect
+	}
+	http.Redirect(w, r, target.String(), status)
+	return true
+}
+
+// SetPathOptions set how request paths are compared with stub paths; stubs added already are kept
+func (m *Mutux) SetPathOptions(o PathOptions) error {
+	if m == nil {
+		return nil
+	}
+	err := o.validate()
+	if err != nil {
+		return fmt.Errorf("Failed to set path options: %s", err.Error())
+	}
+	m.store.setPaths(o)
+	return nil
+}
+
+// PathOptions return how request paths are compared with stub paths
+func (m *Mutux) PathOptions() PathOptions {
+	if m == nil {
+		return PathOptions{}
+	}
+	return m.store.pathOptions()
+}
@@ -0,0 +1,212 @@
+package mutux
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Kinds of path patterns, in order of precedence; an exact path comes before both
+const (
+	patternTemplate = iota
+	patternWildcard
+)
+
+// pathPattern stub path with variables or wildcards, compiled under the path options:
+//
+//	{id}            one segment, captured as variable id
+//	{id:[0-9]+}     text matching the regular expression, captured as variable id
+//	*               any text within one segment, e.g. *.js
+//	**              any number of segments, e.g. static/** for static and everything below it
+//	\*, \{, \}, \\  the character itself, e.g. files/\*.txt; a path with only these is exact
+type pathPattern struct {
+	re *regexp.Regexp
+	// names variable names by the regexp groups capturing them
+	names map[string]string
+	kind  int
+	// literal number of literal characters; more literal patterns are more specific
+	literal int
+}
+
+// pathEscapes characters that stand for themselves in a stub path when preceded by a backslash
+const pathEscapes = `\*{}`
+
+// escaped return whether path has an escaped character at i
+func escaped(path string, i int) bool {
+	return path[i] == '\\' && i+1 < len(path) && strings.IndexByte(pathEscapes, path[i+1]) >= 0
+}
+
+// isPattern return whether path, trimmed already, has variables or wildcards that are not escaped
+func isPattern(path string) bool {
+	for i := 0; i < len(path); i++ {
+		switch {
+		case escaped(path, i):
+			i++
+		case path[i] == '{' || path[i] == '*':
+			return true
+		}
+	}
+	return false
+}
+
+// unescapePath return the request path that path, trimmed already and not a pattern, stands for
+func unescapePath(path string) string {
+	b := strings.Builder{}
+	for i := 0; i < len(path); i++ {
+		if escaped(path, i) {
+			i++
+		}
+		b.WriteByte(path[i])
+	}
+	return b.String()
+}
+
+// escapePath return the stub path standing for the request path path, trimmed already
+func escapePath(path string) string {
+	b := strings.Builder{}
+	for i := 0; i < len(path); i++ {
+		if strings.IndexByte(pathEscapes, path[i]) >= 0 && (path[i] != '\\' || escaped(path, i)) {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(path[i])
+	}
+	return b.String()
+}
+
+// stubKey return the form of stub path, trimmed already, that stubs are filed under: a pattern as given,
+// and an exact path with all its pattern characters escaped
+func stubKey(path string) string {
+	if isPattern(path) {
+		return path
+	}
+	return escapePath(unescapePath(path))
+}
+
+// compilePattern compile path, trimmed already, into a pattern matching paths as compared under o
+func compilePattern(path string, o PathOptions) (*pathPattern, error) {
+	p := &pathPattern{names: map[string]string{}, kind: patternTemplate}
+	if o.TrailingSlash == TrailingSlashIgnore {
+		path = strings.TrimSuffix(path, "/")
+	}
+	expr := strings.Builder{}
+	expr.WriteString("^")
+	if o.CaseInsensitive {
+		expr.WriteString("(?i)")
+	}
+	for i := 0; i < len(path); {
+		switch {
+		case escaped(path, i):
+			expr.WriteString(regexp.QuoteMeta(path[i+1 : i+2]))
+			p.literal++
+			i += 2
+		case path[i] == '{':
+			end, err := closingBrace(path, i)
+			if err != nil {
+				return nil, err
+			}
+			name, re := path[i+1:end], "[^/]+"
+			if j := strings.Index(name, ":"); j >= 0 {
+				name, re = name[:j], name[j+1:]
+			}
+			if name == "" {
+				return nil, fmt.Errorf("unnamed variable in path %s", path)
+			}
+			for _, n := range p.names {
+				if n == name {
+					return nil, fmt.Errorf("variable %s repeated in path %s", name, path)
+				}
+			}
+			if _, err := regexp.Compile(re); err != nil {
+				return nil, fmt.Errorf("invalid regexp of variable %s: %s", name, err.Error())
+			}
+			group := "v" + strconv.Itoa(len(p.names))
+			p.names[group] = name
+			expr.WriteString("(?P<" + group + ">" + re + ")")
+			i = end + 1
+		case strings.HasPrefix(path[i:], "/**") && (i+3 == len(path) || path[i+3] == '/'):
+			// a /** segment also matches no segments at all
+			p.kind = patternWildcard
+			expr.WriteString("(?:/.*)?")
+			i += 3
+		case strings.HasPrefix(path[i:], "**"):
+			p.kind = patternWildcard
+			expr.WriteString(".*")
+			i += 2
+		case path[i] == '*':
+			p.kind = patternWildcard
+			expr.WriteString("[^/]*")
+			i++
+		default:
+			expr.WriteString(regexp.QuoteMeta(path[i : i+1]))
+			p.literal++
+			i++
+		}
+	}
+	if o.TrailingSlash == TrailingSlashIgnore {
+		expr.WriteString("/?")
+	}
+	expr.WriteString("$")
+	re, err := regexp.Compile(expr.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid path %s: %s", path, err.Error())
+	}
+	p.re = re
+	return p, nil
+}
+
+// closingBrace index of the brace closing the one at start, allowing braces nested in a regexp
+func closingBrace(path string, start int) (int, error) {
+	depth := 0
+	for i := start; i < len(path); i++ {
+		switch path[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("unclosed { in path %s", path)
+}
+
+// match return the variables captured from path, trimmed already, and whether it matches
+func (p *pathPattern) match(path string) (map[string]string, bool) {
+	groups := p.re.FindStringSubmatch(path)
+	if groups == nil {
+		return nil, false
+	}
+	vars := map[string]string{}
+	for i, group := range p.re.SubexpNames() {
+		if name, ok := p.names[group]; ok {
+			vars[name] = groups[i]
+		}
+	}
+	return vars, true
+}
+
+// patternMatch stubs on a pattern matching a request path, with the variables captured
+type patternMatch struct {
+	stubs   []Stub
+	pattern *pathPattern
+	vars    map[string]string
+	latest  int
+}
+
+// sortPatternMatches order matches by precedence: templates before wildcards, then the most
+// literal pattern, then the one with the latest stub
+func sortPatternMatches(matches []patternMatch) {
+	sort.SliceStable(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		if a.pattern.kind != b.pattern.kind {
+			return a.pattern.kind < b.pattern.kind
+		}
+		if a.pattern.literal != b.pattern.literal {
+			return a.pattern.literal > b.pattern.literal
+		}
+		return a.latest > b.latest
+	})
+}
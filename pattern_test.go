@@ -0,0 +1,68 @@
+package mutux
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestEscapedPath(t *testing.T) {
+	for _, c := range []struct {
+		path    string
+		pattern bool
+		key     string
+	}{
+		{`files/report.txt`, false, `files/report.txt`},
+		{`files/*.txt`, true, `files/*.txt`},
+		{`files/report\*.txt`, false, `files/report\*.txt`},
+		{`files/\{id}`, false, `files/\{id\}`},
+		{`files/\{id\}`, false, `files/\{id\}`},
+		{`files/a\b`, false, `files/a\b`},
+		{`files/a\\*`, true, `files/a\\*`},
+		{`files/a\\\*`, false, `files/a\\\*`},
+		{`files/\*/{id}`, true, `files/\*/{id}`},
+	} {
+		if isPattern(c.path) != c.pattern {
+			t.Errorf("isPattern(%s) = %v, want %v", c.path, !c.pattern, c.pattern)
+		}
+		if key := stubKey(c.path); key != c.key {
+			t.Errorf("stubKey(%s) = %s, want %s", c.path, key, c.key)
+		}
+		if !c.pattern && stubKey(escapePath(unescapePath(c.path))) != c.key {
+			t.Errorf("stub path %s does not round-trip", c.path)
+		}
+	}
+}
+
+func TestEscapedPathMatch(t *testing.T) {
+	store := newStubStore()
+	literal := "literal"
+	store.setMsg(`weird\*name`, Message{Response: Response{Msg: &literal}})
+	pattern := "pattern"
+	store.setMsg(`star\*/{id}`, Message{Response: Response{Msg: &pattern}})
+	for _, c := range []struct {
+		path string
+		want string
+	}{
+		{"weird*name", "literal"},
+		{"weirdXname", ""},
+		{"star*/1", "pattern"},
+		{"starX/1", ""},
+	} {
+		stub, _, found := store.match(c.path, newMatchRequest(httptest.NewRequest(http.MethodGet, "/"+c.path, nil)))
+		switch {
+		case c.want == "" && found:
+			t.Errorf("%s matched stub %s, want none", c.path, stub.Path)
+		case c.want != "" && (!found || *stub.Msg != c.want):
+			t.Errorf("%s matched %v, want %s", c.path, found, c.want)
+		}
+	}
+	if stubs := store.stubsAt(`weird\*name`); len(stubs) != 1 || stubs[0].Path != `/weird\*name` {
+		t.Errorf("stubsAt(weird\\*name) = %v, want the literal stub", stubs)
+	}
+	store.delMsg(`weird\*name`)
+	r := httptest.NewRequest(http.MethodGet, "/weird*name", nil)
+	if _, _, found := store.match("weird*name", newMatchRequest(r)); found {
+		t.Errorf("weird*name matched after delete")
+	}
+}
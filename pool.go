@@ -0,0 +1,72 @@
+package mutux
+
+import (
+	"fmt"
+)
+
+// Pool set of started Mutux servers on free loopback ports, handed out one at a time so that
+// parallel tests each get an isolated server
+type Pool struct {
+	servers []*Mutux
+	free    chan *Mutux
+}
+
+// NewPool creates and starts n Mutux servers on free loopback ports
+func NewPool(n int) (*Pool, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("pool size must be at least 1")
+	}
+	p := &Pool{free: make(chan *Mutux, n)}
+	for i := 0; i < n; i++ {
+		m, err := NewMutuxWithAddr("127.0.0.1:0")
+		if err == nil {
+			err = m.Start()
+		}
+		if err != nil {
+			p.Close()
+			return nil, fmt.Errorf("Failed to start pool server %d: %s", i+1, err.Error())
+		}
+		p.servers = append(p.servers, m)
+		p.free <- m
+	}
+	return p, nil
+}
+
+// Get take a server from the pool, waiting until one is put back if all are taken
+func (p *Pool) Get() *Mutux {
+	if p == nil {
+		return nil
+	}
+	return <-p.free
+}
+
+// Put reset a server taken with Get to its state when created, with ResetAll, and return it to the pool
+func (p *Pool) Put(m *Mutux) {
+	if p == nil || m == nil {
+		return
+	}
+	m.ResetAll()
+	p.free <- m
+}
+
+// Servers return all servers of the pool, taken or not
+func (p *Pool) Servers() []*Mutux {
+	if p == nil {
+		return nil
+	}
+	return append([]*Mutux(nil), p.servers...)
+}
+
+// Close stop all servers of the pool, returning the first error
+func (p *Pool) Close() error {
+	if p == nil {
+		return nil
+	}
+	var first error
+	for _, m := range p.servers {
+		if err := m.Stop(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
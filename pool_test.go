@@ -0,0 +1,77 @@
+package mutux
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestPoolPutResets check that a server put back into the pool keeps no per-server setting
+func TestPoolPutResets(t *testing.T) {
+	quiet(t)
+	p, err := NewPool(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	dir, err := ioutil.TempDir("", "mutux-pool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	err = ioutil.WriteFile(filepath.Join(dir, "a.json"), []byte(`{"stubs":[{"path":"/a","message":"a"}]}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := p.Get()
+	err = m.WatchDir(dir, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := func(w http.ResponseWriter, r *http.Request) {}
+	m.AddHandlerFunc("/custom", &f, nil)
+	m.SetJournalSize(3)
+	m.SetSeed(1)
+	m.SetReadOnly(true)
+	err = m.MountDir("/static", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.SetFileRoot(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.SetAdminPrefix("/admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Put(m)
+
+	m = p.Get()
+	defer p.Put(m)
+	if len(m.watchers) != 0 {
+		t.Errorf("%d directories still watched", len(m.watchers))
+	}
+	if len(m.CustomHandlerfuncs) != 0 {
+		t.Errorf("%d handler funcs kept", len(m.CustomHandlerfuncs))
+	}
+	if m.journal.size != DefaultJournalSize {
+		t.Errorf("journal size %d, want %d", m.journal.size, DefaultJournalSize)
+	}
+	if m.AdminPrefix() != AdminPrefix {
+		t.Errorf("admin prefix %s, want %s", m.AdminPrefix(), AdminPrefix)
+	}
+	if len(m.Mounts()) != 0 {
+		t.Errorf("mounts kept: %v", m.Mounts())
+	}
+	if m.ReadOnly() || m.FileRoot() != "" {
+		t.Errorf("read-only %v and file root %q kept", m.ReadOnly(), m.FileRoot())
+	}
+	if len(m.Stubs()) != 0 {
+		t.Errorf("stubs kept: %v", m.Stubs())
+	}
+}
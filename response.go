@@ -0,0 +1,209 @@
+package mutux
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Sequence policies deciding what a stub with several responses returns once all were returned
+const (
+	// SequenceRepeatLast keep returning the last response (default)
+	SequenceRepeatLast = "last"
+	// SequenceCycle start over from the first response
+	SequenceCycle = "cycle"
+	// SequenceNotFound answer 404, as if no stub matched
+	SequenceNotFound = "notfound"
+)
+
+// Response message, status and headers returned for a request.
+// Headers and ContentType are merged over the global headers.
+// The body is Msg, or Bytes for binary payloads, or BodySize synthetic bytes. If BodyFile is set, the body
+// is read from that file whenever the stub is added or reloaded, into Msg or, unless it is UTF-8, Bytes;
+// or with BodyFileLive, at each request. A 200 to GET or HEAD answers Range requests.
+// If Template is set, Msg is a text/template rendered for each request; see TemplateData.
+type Response struct {
+	Msg *string `json:"message,omitempty"`
+	// Bytes raw body, base64 in JSON
+	Bytes []byte `json:"bodyBase64,omitempty"`
+	// BodySize size of a synthetic body, for size tests
+	BodySize     *int64       `json:"bodySize,omitempty"`
+	BodyFile     string       `json:"bodyFile,omitempty"`
+	BodyFileLive bool         `json:"bodyFileLive,omitempty"`
+	Status       *int         `json:"status,omitempty"`
+	Headers      HeaderValues `json:"headers,omitempty"`
+	ContentType  string       `json:"contentType,omitempty"`
+	Template     bool         `json:"template,omitempty"`
+	// tmpl Msg parsed, if Template is set
+	tmpl *template.Template
+}
+
+// HeaderValues response headers of a stub. In JSON each header may be given as a single
+// string or as a list of strings, e.g. {"Set-Cookie":["a=1","b=2"]}.
+type HeaderValues map[string][]string
+
+// MarshalJSON write headers with a single value as a string
+func (h HeaderValues) MarshalJSON() ([]byte, error) {
+	m := map[string]interface{}{}
+	for k, values := range h {
+		if len(values) == 1 {
+			m[k] = values[0]
+		} else {
+			m[k] = values
+		}
+	}
+	return json.Marshal(m)
+}
+
+// UnmarshalJSON accept a single string or a list of strings for each header
+func (h *HeaderValues) UnmarshalJSON(b []byte) error {
+	raw := map[string]json.RawMessage{}
+	err := json.Unmarshal(b, &raw)
+	if err != nil {
+		return err
+	}
+	*h = HeaderValues{}
+	for k, v := range raw {
+		var single string
+		if json.Unmarshal(v, &single) == nil {
+			(*h)[k] = []string{single}
+			continue
+		}
+		var list []string
+		err = json.Unmarshal(v, &list)
+		if err != nil {
+			return fmt.Errorf("header %s must be a string or a list of strings", k)
+		}
+		(*h)[k] = list
+	}
+	return nil
+}
+
+// responseHeader headers of msg: global headers first, then the response's own headers and content type over them
+func responseHeader(msg Response, headers map[string]string) http.Header {
+	h := http.Header{}
+	for k, v := range headers {
+		h.Set(k, v)
+	}
+	for k, values := range msg.Headers {
+		h.Del(k)
+		for _, v := range values {
+			h.Add(k, v)
+		}
+	}
+	if msg.ContentType != "" {
+		h.Set("Content-Type", msg.ContentType)
+	}
+	return h
+}
+
+// writeResponse write resp to w: headers first, merged by responseHeader, and only then status and body
+func writeResponse(w http.ResponseWriter, r *http.Request, msg Response, headers map[string]string) {
+	for k, values := range responseHeader(msg, headers) {
+		w.Header()[k] = values
+	}
+	body, size, err := openBody(msg)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer body.Close()
+	if msg.Msg != nil {
+		logf(LogDebug, "\nIn %s message handler, returning: \n%s\n", r.Method, *msg.Msg)
+	} else {
+		logf(LogDebug, "\nIn %s message handler, returning %d bytes\n", r.Method, size)
+	}
+	if *msg.Status == http.StatusOK && (r.Method == http.MethodGet || r.Method == http.MethodHead) {
+		// answers Range requests, and sets Content-Length
+		http.ServeContent(w, r, "", time.Time{}, body)
+		return
+	}
+	if w.Header().Get("Content-Length") == "" {
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	}
+	w.WriteHeader(*msg.Status)
+	io.Copy(w, body)
+}
+
+// readSeekCloser body of a response
+type readSeekCloser interface {
+	io.ReadSeeker
+	io.Closer
+}
+
+type nopCloser struct {
+	io.ReadSeeker
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+// openBody open the body of resp, prepared already, and return it with its size
+func openBody(resp Response) (readSeekCloser, int64, error) {
+	switch {
+	case resp.BodyFileLive:
+		f, err := os.Open(resp.BodyFile)
+		if err != nil {
+			return nil, 0, fmt.Errorf("Error reading body file: %s", err.Error())
+		}
+		size, err := f.Seek(0, io.SeekEnd)
+		if err == nil {
+			_, err = f.Seek(0, io.SeekStart)
+		}
+		if err != nil {
+			f.Close()
+			return nil, 0, fmt.Errorf("Error reading body file: %s", err.Error())
+		}
+		return f, size, nil
+	case resp.Bytes != nil:
+		return nopCloser{bytes.NewReader(resp.Bytes)}, int64(len(resp.Bytes)), nil
+	case resp.BodySize != nil:
+		return nopCloser{&syntheticBody{size: *resp.BodySize}}, *resp.BodySize, nil
+	}
+	return nopCloser{strings.NewReader(*resp.Msg)}, int64(len(*resp.Msg)), nil
+}
+
+// syntheticPattern text a synthetic body repeats
+const syntheticPattern = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ\n"
+
+// syntheticBody body of size bytes repeating syntheticPattern, generated as it is read
+type syntheticBody struct {
+	size   int64
+	offset int64
+}
+
+func (s *syntheticBody) Read(p []byte) (int, error) {
+	if s.offset >= s.size {
+		return 0, io.EOF
+	}
+	if rest := s.size - s.offset; int64(len(p)) > rest {
+		p = p[:rest]
+	}
+	for i := range p {
+		p[i] = syntheticPattern[(s.offset+int64(i))%int64(len(syntheticPattern))]
+	}
+	s.offset += int64(len(p))
+	return len(p), nil
+}
+
+func (s *syntheticBody) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += s.offset
+	case io.SeekEnd:
+		offset += s.size
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("seek before start of body")
+	}
+	s.offset = offset
+	return offset, nil
+}
@@ -0,0 +1,32 @@
+package mutux
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/gorilla/mux"
+)
+
+// routerSwitch stable http.Handler given to the server; it serves the current router,
+// which is swapped whenever handler funcs change, so changes apply without rebinding the listener
+type routerSwitch struct {
+	mu     sync.RWMutex
+	router *mux.Router
+}
+
+func (s *routerSwitch) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	router := s.router
+	s.mu.RUnlock()
+	if router == nil {
+		http.NotFound(w, r)
+		return
+	}
+	router.ServeHTTP(w, r)
+}
+
+func (s *routerSwitch) swap(r *mux.Router) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.router = r
+}
@@ -0,0 +1,102 @@
+package mutux
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func sequence(kind string, msgs ...string) Message {
+	responses := make([]Response, len(msgs))
+	for i := range msgs {
+		responses[i] = Response{Msg: &msgs[i]}
+	}
+	return Message{Responses: responses, Sequence: kind}
+}
+
+func TestSequences(t *testing.T) {
+	m := newTestServer(t)
+	for _, c := range []struct {
+		sequence string
+		want     []string
+	}{
+		{"", []string{"a", "b", "b", "b"}},
+		{SequenceRepeatLast, []string{"a", "b", "b", "b"}},
+		{SequenceCycle, []string{"a", "b", "a", "b"}},
+		{SequenceNotFound, []string{"a", "b", "404", "404"}},
+	} {
+		path := "seq" + c.sequence
+		_, err := m.AddStub(path, sequence(c.sequence, "a", "b"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := []string{}
+		for range c.want {
+			status, body := get(t, m, "/"+path)
+			if status == http.StatusNotFound {
+				if !strings.Contains(body, "response sequence exhausted") {
+					t.Errorf("sequence %q answered 404 %q, want it exhausted", c.sequence, body)
+				}
+				body = "404"
+			}
+			got = append(got, body)
+		}
+		if strings.Join(got, ",") != strings.Join(c.want, ",") {
+			t.Errorf("sequence %q answered %v, want %v", c.sequence, got, c.want)
+		}
+	}
+
+	if _, err := m.AddStub("bad", sequence("random", "a")); err == nil || !strings.Contains(err.Error(), "unknown sequence") {
+		t.Errorf("AddStub with unknown sequence = %v, want an error", err)
+	}
+}
+
+func TestSequenceReset(t *testing.T) {
+	m := newTestServer(t)
+	one, err := m.AddStub("one", sequence(SequenceNotFound, "a", "b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.AddStub("two", sequence("", "a", "b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := func(path, want string) {
+		t.Helper()
+		if _, body := get(t, m, "/"+path); body != want {
+			t.Errorf("GET /%s = %q, want %q", path, body, want)
+		}
+	}
+	next("one", "a")
+	next("one", "b")
+	next("two", "a")
+
+	if !m.ResetSequence(one.ID) || m.ResetSequence("none") {
+		t.Errorf("ResetSequence reported the wrong stubs as existing")
+	}
+	next("one", "a")
+	next("two", "b")
+
+	req, err := http.NewRequest(http.MethodDelete, m.URL()+AdminPrefix+"/sequences", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("DELETE sequences = %d, want 204", resp.StatusCode)
+	}
+	next("one", "a")
+	next("two", "a")
+
+	// replacing a stub starts its sequence over
+	next("one", "b")
+	_, err = m.AddStub("one", sequence(SequenceNotFound, "c", "d"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	next("one", "c")
+}
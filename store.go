@@ -0,0 +1,563 @@
+package mutux
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Stub message served at a path, for requests satisfying the message's match conditions.
+// Path is a pattern as added, or an exact path with its *, { and } characters, and backslashes before them, escaped.
+// Position is the index of the next of its Responses to return.
+type Stub struct {
+	ID       string `json:"id"`
+	Path     string `json:"path"`
+	Position int    `json:"position"`
+	Message
+	// source watched directory the stub was loaded from, if any
+	source string
+	// pattern compiled path, if it has variables or wildcards
+	pattern *pathPattern
+}
+
+// stubStore synchronized storage of stubs, global headers, PUT setting, path options and unmatched response,
+// shared between the Mutux API and the server goroutines
+type stubStore struct {
+	mu sync.RWMutex
+	// pathmsg stubs by their path, trimmed and folded by paths
+	pathmsg  map[string][]Stub
+	paths    PathOptions
+	headers  map[string]string
+	allowPUT bool
+	lastID   int
+	// readOnly whether changes through the HTTP API are refused; kept by reset
+	readOnly bool
+	// fileRoot absolute directory that stubs and mounts added through the HTTP API may read; none if empty.
+	// Kept by reset.
+	fileRoot string
+	// unmatched prepared answer to requests no stub matches
+	unmatched UnmatchedResponse
+	// orders stub ids expected to be first called in order, declared with ExpectOrder
+	orders [][]string
+	// counters values of the counter template helper, by name
+	counters map[string]int
+	// calls number of requests each stub answered, by id; unlike the journal, not bounded nor reset with it
+	calls map[string]int
+	// sourceHeaders headers set by each watched directory, so that they are removed with it
+	sourceHeaders map[string]map[string]string
+}
+
+func newStubStore() *stubStore {
+	s := &stubStore{}
+	s.reset()
+	return s
+}
+
+// reset drop all stubs, and restore default headers, PUT setting, path options and unmatched response
+func (s *stubStore) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pathmsg = map[string][]Stub{}
+	s.headers = map[string]string{
+		"Content-type": "application/json",
+	}
+	s.allowPUT = true
+	s.paths = PathOptions{}
+	s.unmatched, _ = prepareUnmatched(UnmatchedResponse{})
+	s.orders = nil
+	s.counters = map[string]int{}
+	s.calls = map[string]int{}
+	s.sourceHeaders = map[string]map[string]string{}
+}
+
+// setMsg add msg to path, replacing the stub on path with the same match conditions
+func (s *stubStore) setMsg(path string, msg Message) Stub {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.set(path, msg)
+}
+
+// key return the key of the stubs on stub path, trimmed already; caller must hold mu
+func (s *stubStore) key(path string) string {
+	return s.paths.fold(stubKey(path))
+}
+
+// set add msg to path; caller must hold mu
+func (s *stubStore) set(path string, msg Message) Stub {
+	path = stubKey(path)
+	key := msg.Match.key()
+	stubs := s.pathmsg[s.paths.fold(path)]
+	for i, stub := range stubs {
+		if stub.Match.key() == key {
+			stubs[i].Message = msg
+			stubs[i].Position = 0
+			stubs[i].source = ""
+			delete(s.calls, stubs[i].ID)
+			return stubs[i]
+		}
+	}
+	s.lastID++
+	stub := Stub{
+		ID:      strconv.Itoa(s.lastID),
+		Path:    "/" + path,
+		Message: msg,
+	}
+	if isPattern(path) {
+		// paths of stubs are validated already
+		stub.pattern, _ = compilePattern(path, s.paths)
+	}
+	s.pathmsg[s.paths.fold(path)] = append(stubs, stub)
+	return stub
+}
+
+// load replace all stubs, whose paths are given trimmed, and replace headers and PUT setting unless nil
+func (s *stubStore) load(stubs []Stub, headers map[string]string, allowPUT *bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pathmsg = map[string][]Stub{}
+	for _, stub := range stubs {
+		s.set(stub.Path, stub.Message)
+	}
+	if headers != nil {
+		s.headers = map[string]string{}
+		for k, v := range headers {
+			s.headers[k] = v
+		}
+	}
+	if allowPUT != nil {
+		s.allowPUT = *allowPUT
+	}
+}
+
+// replaceSource replace the stubs loaded from source, whose paths are given trimmed. A stub loaded
+// from source before, on the same path with the same match conditions, is updated in place, keeping
+// its ID and sequence position.
+func (s *stubStore) replaceSource(source string, stubs []Stub) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	loaded := map[string]bool{}
+	for _, stub := range stubs {
+		if old := s.findMatch(stub.Path, stub.Match); old != nil && old.source == source {
+			old.Message = stub.Message
+			loaded[old.ID] = true
+			continue
+		}
+		added := s.set(stub.Path, stub.Message)
+		s.find(added.ID).source = source
+		loaded[added.ID] = true
+	}
+	for path, pathstubs := range s.pathmsg {
+		kept := []Stub{}
+		for _, stub := range pathstubs {
+			if stub.source != source || loaded[stub.ID] {
+				kept = append(kept, stub)
+			}
+		}
+		if len(kept) == 0 {
+			delete(s.pathmsg, path)
+		} else {
+			s.pathmsg[path] = kept
+		}
+	}
+}
+
+// replaceSourceHeaders set the headers loaded from source, and delete those it no longer has,
+// unless they were changed since
+func (s *stubStore) replaceSourceHeaders(source string, headers map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k, v := range s.sourceHeaders[source] {
+		if _, ok := headers[k]; !ok && s.headers[k] == v {
+			delete(s.headers, k)
+		}
+	}
+	s.sourceHeaders[source] = map[string]string{}
+	for k, v := range headers {
+		s.headers[k] = v
+		s.sourceHeaders[source][k] = v
+	}
+}
+
+// findMatch return the stored stub on path, trimmed already, with the same match conditions as match;
+// caller must hold mu
+func (s *stubStore) findMatch(path string, match Match) *Stub {
+	key := match.key()
+	stubs := s.pathmsg[s.key(path)]
+	for i := range stubs {
+		if stubs[i].Match.key() == key {
+			return &stubs[i]
+		}
+	}
+	return nil
+}
+
+// hasMsg return whether path has a stub with the same match conditions as msg
+func (s *stubStore) hasMsg(path string, msg Message) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	key := msg.Match.key()
+	for _, stub := range s.pathmsg[s.key(path)] {
+		if stub.Match.key() == key {
+			return true
+		}
+	}
+	return false
+}
+
+// match return the stub for path matching r, and the variables captured from path. Stubs on exactly
+// path come first, then those on templates, then on wildcards; see sortPatternMatches.
+func (s *stubStore) match(path string, r *matchRequest) (Stub, map[string]string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if stubs := s.pathmsg[s.paths.fold(escapePath(path))]; len(stubs) > 0 && stubs[0].pattern == nil {
+		if stub, found := bestMatch(stubs, r); found {
+			return stub, map[string]string{}, true
+		}
+	}
+	matches := []patternMatch{}
+	for _, stubs := range s.pathmsg {
+		if len(stubs) == 0 || stubs[0].pattern == nil {
+			continue
+		}
+		if vars, ok := stubs[0].pattern.match(path); ok {
+			latest := 0
+			for _, stub := range stubs {
+				id, _ := strconv.Atoi(stub.ID)
+				latest = maxInt(latest, id)
+			}
+			matches = append(matches, patternMatch{stubs: stubs, pattern: stubs[0].pattern, vars: vars, latest: latest})
+		}
+	}
+	sortPatternMatches(matches)
+	for _, m := range matches {
+		if stub, found := bestMatch(m.stubs, r); found {
+			return stub, m.vars, true
+		}
+	}
+	return Stub{}, nil, false
+}
+
+// bestMatch return the stub matching r with the highest priority, then the most specific conditions;
+// on a tie the latest added wins
+func bestMatch(stubs []Stub, r *matchRequest) (Stub, bool) {
+	best, bestScore, found := Stub{}, -1, false
+	for _, stub := range stubs {
+		ok, score := stub.Match.matches(r)
+		if !ok {
+			continue
+		}
+		if !found || stub.Priority > best.Priority || (stub.Priority == best.Priority && score >= bestScore) {
+			best, bestScore, found = stub, score, true
+		}
+	}
+	return best, found
+}
+
+// nextResponse return the response the stub with id gives to the current request, advancing its
+// position; false once a SequenceNotFound sequence is exhausted, or if the stub no longer exists
+func (s *stubStore) nextResponse(id string) (Response, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stub := s.find(id)
+	if stub == nil {
+		return Response{}, false
+	}
+	if len(stub.Responses) == 0 {
+		return stub.Response, true
+	}
+	i := stub.Position
+	if i >= len(stub.Responses) {
+		switch stub.Sequence {
+		case SequenceCycle:
+			i = i % len(stub.Responses)
+		case SequenceNotFound:
+			return Response{}, false
+		default:
+			return stub.Responses[len(stub.Responses)-1], true
+		}
+	}
+	stub.Position++
+	if stub.Sequence == SequenceCycle {
+		stub.Position = stub.Position % len(stub.Responses)
+	}
+	return stub.Responses[i], true
+}
+
+// resetPosition rewind the response sequence of the stub with id, or of all stubs if id is empty
+func (s *stubStore) resetPosition(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	found := false
+	for _, stubs := range s.pathmsg {
+		for i := range stubs {
+			if id == "" || stubs[i].ID == id {
+				stubs[i].Position = 0
+				found = true
+			}
+		}
+	}
+	return found
+}
+
+// find return the stored stub with id; caller must hold mu
+func (s *stubStore) find(id string) *Stub {
+	for _, stubs := range s.pathmsg {
+		for i := range stubs {
+			if stubs[i].ID == id {
+				return &stubs[i]
+			}
+		}
+	}
+	return nil
+}
+
+// stubsAt return a copy of all stubs on path
+func (s *stubStore) stubsAt(path string) []Stub {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]Stub(nil), s.pathmsg[s.key(path)]...)
+}
+
+// delMsg delete all stubs on path
+func (s *stubStore) delMsg(path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.pathmsg, s.key(path))
+}
+
+// delMatch delete the stub on path with the same match conditions as match
+func (s *stubStore) delMatch(path string, match Match) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := match.key()
+	path = s.key(path)
+	for i, stub := range s.pathmsg[path] {
+		if stub.Match.key() == key {
+			s.remove(path, i)
+			return true
+		}
+	}
+	return false
+}
+
+// delID delete the stub with id
+func (s *stubStore) delID(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for path, stubs := range s.pathmsg {
+		for i, stub := range stubs {
+			if stub.ID == id {
+				s.remove(path, i)
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// remove delete the i-th stub on path, folded already; caller must hold mu
+func (s *stubStore) remove(path string, i int) {
+	stubs := append(append([]Stub(nil), s.pathmsg[path][:i]...), s.pathmsg[path][i+1:]...)
+	if len(stubs) == 0 {
+		delete(s.pathmsg, path)
+		return
+	}
+	s.pathmsg[path] = stubs
+}
+
+// stubs return a copy of all stubs, ordered by path and then by creation
+func (s *stubStore) stubs() []Stub {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	stubs := []Stub{}
+	for _, pathstubs := range s.pathmsg {
+		stubs = append(stubs, pathstubs...)
+	}
+	sort.SliceStable(stubs, func(i, j int) bool {
+		if stubs[i].Path != stubs[j].Path {
+			return stubs[i].Path < stubs[j].Path
+		}
+		a, _ := strconv.Atoi(stubs[i].ID)
+		b, _ := strconv.Atoi(stubs[j].ID)
+		return a < b
+	})
+	return stubs
+}
+
+// msgs return a copy of all messages, by path
+func (s *stubStore) msgs() map[string][]Message {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	msgs := make(map[string][]Message, len(s.pathmsg))
+	for _, stubs := range s.pathmsg {
+		for _, stub := range stubs {
+			path := strings.TrimLeft(stub.Path, "/")
+			msgs[path] = append(msgs[path], stub.Message)
+		}
+	}
+	return msgs
+}
+
+// addOrder declare that the stubs with ids are expected to be first called in order
+func (s *stubStore) addOrder(ids []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(ids) < 2 {
+		return fmt.Errorf("an order needs at least 2 stubs")
+	}
+	for _, id := range ids {
+		if s.find(id) == nil {
+			return fmt.Errorf("stub with id %s not found", id)
+		}
+	}
+	s.orders = append(s.orders, append([]string(nil), ids...))
+	return nil
+}
+
+// addCall count a request answered by the stub with id
+func (s *stubStore) addCall(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls[id]++
+}
+
+// callCount number of requests the stub with id answered
+func (s *stubStore) callCount(id string) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.calls[id]
+}
+
+// expectations return a copy of all stubs, of the orders declared across them, and of the number
+// of requests each stub answered
+func (s *stubStore) expectations() ([]Stub, [][]string, map[string]int) {
+	stubs := s.stubs()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	orders := make([][]string, len(s.orders))
+	copy(orders, s.orders)
+	calls := map[string]int{}
+	for id, n := range s.calls {
+		calls[id] = n
+	}
+	return stubs, orders, calls
+}
+
+// nextCount increment the counter with name, returning its new value
+func (s *stubStore) nextCount(name string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counters[name]++
+	return s.counters[name]
+}
+
+func (s *stubStore) setHeader(name, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.headers[name] = value
+}
+
+func (s *stubStore) delHeader(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.headers, name)
+}
+
+// headerCopy return a copy of all global headers
+func (s *stubStore) headerCopy() map[string]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	headers := make(map[string]string, len(s.headers))
+	for k, v := range s.headers {
+		headers[k] = v
+	}
+	return headers
+}
+
+func (s *stubStore) setAllowPUT(allow bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.allowPUT = allow
+}
+
+func (s *stubStore) putAllowed() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.allowPUT
+}
+
+func (s *stubStore) setReadOnly(readOnly bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.readOnly = readOnly
+}
+
+func (s *stubStore) isReadOnly() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.readOnly
+}
+
+func (s *stubStore) setFileRoot(root string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fileRoot = root
+}
+
+func (s *stubStore) filesRoot() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.fileRoot
+}
+
+// setPaths set the path options, validated already, and refile the stubs by their paths folded under them.
+// Stubs whose paths become equal are merged, in the order they were added.
+func (s *stubStore) setPaths(o PathOptions) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.paths = o
+	pathmsg := map[string][]Stub{}
+	for _, stubs := range s.pathmsg {
+		for _, stub := range stubs {
+			path := strings.TrimLeft(stub.Path, "/")
+			if stub.pattern != nil {
+				stub.pattern, _ = compilePattern(path, o)
+			}
+			pathmsg[o.fold(path)] = append(pathmsg[o.fold(path)], stub)
+		}
+	}
+	for _, stubs := range pathmsg {
+		sort.SliceStable(stubs, func(i, j int) bool {
+			a, _ := strconv.Atoi(stubs[i].ID)
+			b, _ := strconv.Atoi(stubs[j].ID)
+			return a < b
+		})
+	}
+	s.pathmsg = pathmsg
+}
+
+func (s *stubStore) pathOptions() PathOptions {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.paths
+}
+
+// setUnmatched set the unmatched response, prepared already
+func (s *stubStore) setUnmatched(u UnmatchedResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.unmatched = u
+}
+
+func (s *stubStore) unmatchedResponse() UnmatchedResponse {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	u := s.unmatched
+	if u.Response != nil {
+		resp := *u.Response
+		u.Response = &resp
+	}
+	return u
+}
@@ -0,0 +1,132 @@
+package mutux
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// TemplateData data a templated response is rendered with, e.g. {{.Vars.id}} or {{.JSON.user.name}}
+type TemplateData struct {
+	// Vars variables captured from the request path by the stub path, e.g. id for /users/{id}
+	Vars   map[string]string
+	Method string
+	Path   string
+	// Query first value of each query param
+	Query map[string]string
+	// Headers first value of each request header, by canonical name, e.g. {{index .Headers "X-Request-Id"}}
+	Headers map[string]string
+	Body    string
+	// JSON request body decoded, or nil if it is not JSON
+	JSON interface{}
+}
+
+// newTemplateData data to render a response to r with, vars captured from its path
+func newTemplateData(r *matchRequest, vars map[string]string) TemplateData {
+	data := TemplateData{
+		Vars:    vars,
+		Method:  r.Method,
+		Path:    r.URL.Path,
+		Query:   map[string]string{},
+		Headers: map[string]string{},
+		Body:    string(r.body),
+	}
+	for k, values := range r.URL.Query() {
+		data.Query[k] = values[0]
+	}
+	for k, values := range r.Header {
+		data.Headers[k] = values[0]
+	}
+	if doc, err := r.jsonBody(); err == nil {
+		data.JSON = doc
+	}
+	return data
+}
+
+// templateFuncs helpers available to templated responses; counters are kept in store, and random
+// values drawn from rng, so that SetSeed makes them reproducible. Both may be nil to parse only.
+//
+//	now                 current time, RFC 3339; {{now "2006-01-02"}} formats it with a layout
+//	uuid                random UUID
+//	randomInt 1 6       random integer from 1 to 6
+//	randomString 8      random alphanumeric string of 8 characters
+//	counter "orders"    1 on the first call with this name, then 2 and so on, until Reset
+//	base64 "x"          standard base64 encoding; base64Decode decodes
+//	json .JSON.user     value as JSON, e.g. to quote a string
+func templateFuncs(store *stubStore, rng *faultRand) template.FuncMap {
+	return template.FuncMap{
+		"now": func(layout ...string) string {
+			if len(layout) > 0 {
+				return time.Now().Format(layout[0])
+			}
+			return time.Now().Format(time.RFC3339)
+		},
+		"uuid": func() string {
+			b := make([]byte, 16)
+			for i := range b {
+				b[i] = byte(rng.intn(256))
+			}
+			b[6] = b[6]&0x0f | 0x40
+			b[8] = b[8]&0x3f | 0x80
+			return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+		},
+		"randomInt": func(min, max int) (int, error) {
+			if max < min {
+				return 0, fmt.Errorf("randomInt max %d is less than min %d", max, min)
+			}
+			return min + rng.intn(max-min+1), nil
+		},
+		"randomString": func(n int) string {
+			const letters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+			b := strings.Builder{}
+			for i := 0; i < n; i++ {
+				b.WriteByte(letters[rng.intn(len(letters))])
+			}
+			return b.String()
+		},
+		"counter": func(name string) int {
+			return store.nextCount(name)
+		},
+		"base64": func(s string) string {
+			return base64.StdEncoding.EncodeToString([]byte(s))
+		},
+		"base64Decode": func(s string) (string, error) {
+			b, err := base64.StdEncoding.DecodeString(s)
+			return string(b), err
+		},
+		"json": func(v interface{}) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+	}
+}
+
+// parseTemplate parse msg as the template of a response
+func parseTemplate(msg string) (*template.Template, error) {
+	return template.New("message").Option("missingkey=zero").Funcs(templateFuncs(nil, nil)).Parse(msg)
+}
+
+// renderResponse return resp with its message rendered for r, if it is a template, with vars captured
+// from the path and helpers backed by store and rng; otherwise resp is returned as is
+func renderResponse(resp Response, r *matchRequest, vars map[string]string, store *stubStore, rng *faultRand) (Response, error) {
+	if resp.tmpl == nil {
+		return resp, nil
+	}
+	tmpl, err := resp.tmpl.Clone()
+	if err != nil {
+		return resp, fmt.Errorf("Failed to render template: %s", err.Error())
+	}
+	buf := bytes.Buffer{}
+	err = tmpl.Funcs(templateFuncs(store, rng)).Execute(&buf, newTemplateData(r, vars))
+	if err != nil {
+		return resp, fmt.Errorf("Failed to render template: %s", err.Error())
+	}
+	msg := buf.String()
+	resp.Msg = &msg
+	resp.tmpl = nil
+	return resp, nil
+}
@@ -0,0 +1,71 @@
+package mutux
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func templateStub(msg string) Message {
+	return Message{Response: Response{Msg: &msg, Template: true}}
+}
+
+func TestTemplateRender(t *testing.T) {
+	m := newTestServer(t)
+	m.SetSeed(1)
+	for _, c := range []struct {
+		path string
+		msg  string
+		req  string
+		want string
+	}{
+		{"users/{id}", `user {{.Vars.id}}`, "/users/7", "user 7"},
+		{"query", `page {{.Query.page}}, size {{.Query.size}}`, "/query?page=2", "page 2, size "},
+		{"counter", `{{counter "c"}} {{counter "c"}}`, "/counter", "1 2"},
+		{"base64", `{{base64 "hi"}} {{base64Decode "aGk="}}`, "/base64", "aGk= hi"},
+		{"method", `{{.Method}} {{.Path}}`, "/method", "GET /method"},
+	} {
+		_, err := m.AddStub(c.path, templateStub(c.msg))
+		if err != nil {
+			t.Fatalf("AddStub %s: %s", c.path, err.Error())
+		}
+		if status, body := get(t, m, c.req); status != http.StatusOK || body != c.want {
+			t.Errorf("GET %s = %d %q, want 200 %q", c.req, status, body, c.want)
+		}
+	}
+}
+
+func TestTemplateErrors(t *testing.T) {
+	m := newTestServer(t)
+	for _, c := range []struct {
+		name string
+		msg  Message
+		err  string
+	}{
+		{"unclosed action", templateStub(`{{.Vars.id`), "invalid template"},
+		{"unknown function", templateStub(`{{nope}}`), "invalid template"},
+		{"no text message", Message{Response: Response{Bytes: []byte{0xff}, Template: true}}, "template needs a text message"},
+	} {
+		if _, err := m.AddStub("bad", c.msg); err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%s: AddStub = %v, want an error containing %q", c.name, err, c.err)
+		}
+	}
+	if status, body := put(t, m, AdminPrefix+"/stubs/bad", `{"message":"{{if}}","template":true}`); status != http.StatusBadRequest {
+		t.Errorf("PUT invalid template = %d %s, want 400", status, body)
+	}
+	if len(m.Stubs()) != 0 {
+		t.Fatalf("stubs added with invalid templates: %v", m.Stubs())
+	}
+
+	// templates that parse may still fail for a request
+	for _, msg := range []string{`{{randomInt 6 1}}`, `{{base64Decode .Query.b}}`, `{{index .Vars "id" "x"}}`} {
+		_, err := m.AddStub("render", templateStub(msg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		status, body := get(t, m, "/render?b=%25")
+		if status != http.StatusInternalServerError || !strings.Contains(body, "Failed to render template") {
+			t.Errorf("template %s rendered %d %q, want 500", msg, status, body)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package mutux
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// DefaultClosest number of closest stubs listed in the response to an unmatched request
+const DefaultClosest = 3
+
+// UnmatchedResponse answer to requests that no stub or handler func matches. Unless Response is set,
+// the body is JSON listing the closest stubs, most similar first, with the reasons each did not match.
+type UnmatchedResponse struct {
+	// Status defaults to 404
+	Status int `json:"status,omitempty"`
+	// Closest maximum number of stubs listed; 0 defaults to DefaultClosest, negative lists none
+	Closest int `json:"closest,omitempty"`
+	// Response written instead of the JSON body, e.g. to mimic the 404 page of the real server
+	Response *Response `json:"response,omitempty"`
+}
+
+// StubNearMiss stub that came close to matching a request, and why it did not
+type StubNearMiss struct {
+	ID      string   `json:"id"`
+	Path    string   `json:"path"`
+	Method  string   `json:"method,omitempty"`
+	Reasons []string `json:"reasons"`
+}
+
+// unmatchedBody JSON body of the default unmatched response
+type unmatchedBody struct {
+	Error   string         `json:"error"`
+	Closest []StubNearMiss `json:"closest"`
+}
+
+// prepareUnmatched default status and number of closest stubs of u, and prepare its response if any,
+// whose status defaults to that of u
+func prepareUnmatched(u UnmatchedResponse) (UnmatchedResponse, error) {
+	if u.Status == 0 {
+		u.Status = http.StatusNotFound
+	}
+	if u.Status < 100 || u.Status > 999 {
+		return u, fmt.Errorf("invalid status %d", u.Status)
+	}
+	if u.Closest == 0 {
+		u.Closest = DefaultClosest
+	}
+	if u.Response != nil {
+		resp := *u.Response
+		if resp.Status == nil {
+			status := u.Status
+			resp.Status = &status
+		}
+		resp, err := prepareResponse(resp)
+		if err != nil {
+			return u, fmt.Errorf("response: %s", err.Error())
+		}
+		u.Response = &resp
+	}
+	return u, nil
+}
+
+// closestStubs at most n stubs that came closest to matching r, requested at path and compared under paths,
+// most similar first; stubs on unrelated paths are left out
+func closestStubs(stubs []Stub, path string, r *matchRequest, paths PathOptions, n int) []StubNearMiss {
+	type scored struct {
+		miss  StubNearMiss
+		score int
+	}
+	candidates := []scored{}
+	for _, stub := range stubs {
+		score, reasons := compare(stub, path, r, paths)
+		if score < pathWeight/2 || len(reasons) == 0 {
+			continue
+		}
+		candidates = append(candidates, scored{StubNearMiss{ID: stub.ID, Path: stub.Path, Method: stub.Method, Reasons: reasons}, score})
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].score > candidates[j].score
+	})
+	misses := []StubNearMiss{}
+	for i := 0; i < len(candidates) && i < n; i++ {
+		misses = append(misses, candidates[i].miss)
+	}
+	return misses
+}
+
+// unmatchedFunc handler answering requests that no stub or handler func matched with the unmatched
+// response of store, and recording the closest stubs in the journal
+func unmatchedFunc(store *stubStore, rng *faultRand) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u := store.unmatchedResponse()
+		req := newMatchRequest(r)
+		closest := []StubNearMiss{}
+		if u.Closest > 0 {
+			closest = closestStubs(store.stubs(), strings.TrimLeft(r.URL.Path, "/"), req, store.pathOptions(), u.Closest)
+		}
+		setUnmatched(r, closest)
+		logf(LogInfo, "no stub matches %s %s\n", r.Method, r.URL.Path)
+		for _, miss := range closest {
+			logf(LogInfo, "\tclosest stub %s (%s): %s\n", miss.ID, miss.Path, strings.Join(miss.Reasons, "; "))
+		}
+		if u.Response != nil {
+			resp, err := renderResponse(*u.Response, req, map[string]string{}, store, rng)
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			writeResponse(w, r, resp, store.headerCopy())
+			return
+		}
+		writeJSON(w, u.Status, unmatchedBody{
+			Error:   fmt.Sprintf("no stub matches %s %s", r.Method, r.URL.Path),
+			Closest: closest,
+		})
+	}
+}
+
+// SetUnmatchedResponse set the answer to requests that no stub or handler func matches
+func (m *Mutux) SetUnmatchedResponse(u UnmatchedResponse) error {
+	if m == nil {
+		return nil
+	}
+	u, err := prepareUnmatched(u)
+	if err != nil {
+		return fmt.Errorf("Failed to set unmatched response: %s", err.Error())
+	}
+	m.store.setUnmatched(u)
+	return nil
+}
+
+// UnmatchedResponse return the answer to requests that no stub or handler func matches
+func (m *Mutux) UnmatchedResponse() UnmatchedResponse {
+	if m == nil {
+		return UnmatchedResponse{}
+	}
+	return m.store.unmatchedResponse()
+}
@@ -0,0 +1,286 @@
+package mutux
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultWatchInterval how often a watched directory is checked for changes, unless given to WatchDir
+const DefaultWatchInterval = time.Second
+
+// dirWatcher poll a directory of stub config files, and the body files they refer to,
+// reloading the stubs from it when any of them changes
+type dirWatcher struct {
+	m        *Mutux
+	dir      string
+	interval time.Duration
+	stamps   map[string]fileStamp
+	stop     chan struct{}
+	done     chan struct{}
+	mu       sync.Mutex
+	err      error
+}
+
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// WatchDir load stubs from the JSON and YAML config files in dir, and reload them whenever a file in dir,
+// or a body file they refer to, changes. Stubs from dir replace those of the previous load; stubs added
+// otherwise are kept; stubs whose path and match conditions are unchanged keep their ID and sequence position.
+// Headers and mounts removed from the files are removed too. If a reload fails, the error is reported and the last good stubs are kept.
+// Watching ends with StopWatching or Stop.
+func (m *Mutux) WatchDir(dir string, interval time.Duration) error {
+	if m == nil {
+		return nil
+	}
+	if interval <= 0 {
+		interval = DefaultWatchInterval
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	w := &dirWatcher{
+		m:        m,
+		dir:      dir,
+		interval: interval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	err = w.reload()
+	if err != nil {
+		return err
+	}
+	m.watchMu.Lock()
+	m.watchers = append(m.watchers, w)
+	m.watchMu.Unlock()
+	go w.run()
+	return nil
+}
+
+// StopWatching stop watching all stub directories; their stubs are kept
+func (m *Mutux) StopWatching() {
+	if m == nil {
+		return
+	}
+	m.watchMu.Lock()
+	watchers := m.watchers
+	m.watchers = nil
+	m.watchMu.Unlock()
+	for _, w := range watchers {
+		close(w.stop)
+		<-w.done
+	}
+}
+
+// WatchErrors return the errors of the latest failed reload of each watched directory
+func (m *Mutux) WatchErrors() []error {
+	if m == nil {
+		return nil
+	}
+	m.watchMu.Lock()
+	defer m.watchMu.Unlock()
+	errs := []error{}
+	for _, w := range m.watchers {
+		w.mu.Lock()
+		if w.err != nil {
+			errs = append(errs, w.err)
+		}
+		w.mu.Unlock()
+	}
+	return errs
+}
+
+func (w *dirWatcher) run() {
+	defer close(w.done)
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			if w.changed() {
+				err := w.reload()
+				if err != nil {
+					logf(LogError, "\nFailed to reload stubs, keeping last good configuration: %s\n", err.Error())
+				}
+			}
+		}
+	}
+}
+
+// configFiles return the config files in dir, sorted
+func (w *dirWatcher) configFiles() ([]string, error) {
+	infos, err := ioutil.ReadDir(w.dir)
+	if err != nil {
+		return nil, err
+	}
+	files := []string{}
+	for _, info := range infos {
+		ext := filepath.Ext(info.Name())
+		if !info.IsDir() && (ext == ".json" || isYAML(info.Name())) {
+			files = append(files, filepath.Join(w.dir, info.Name()))
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// snapshot stamp the config files and the body files they refer to
+func (w *dirWatcher) snapshot(files []string, cfgs []Config) map[string]fileStamp {
+	stamps := map[string]fileStamp{}
+	stamp := func(file string) {
+		if info, err := os.Stat(file); err == nil {
+			stamps[file] = fileStamp{modTime: info.ModTime(), size: info.Size()}
+		} else {
+			stamps[file] = fileStamp{}
+		}
+	}
+	for _, file := range files {
+		stamp(file)
+	}
+	for _, cfg := range cfgs {
+		if cfg.Unmatched != nil && cfg.Unmatched.Response != nil && cfg.Unmatched.Response.BodyFile != "" {
+			stamp(cfg.Unmatched.Response.BodyFile)
+		}
+		for _, stub := range cfg.Stubs {
+			if stub.BodyFile != "" {
+				stamp(stub.BodyFile)
+			}
+			for _, resp := range stub.Responses {
+				if resp.BodyFile != "" {
+					stamp(resp.BodyFile)
+				}
+			}
+		}
+	}
+	return stamps
+}
+
+// changed return whether any watched file was added, removed or modified since the last reload
+func (w *dirWatcher) changed() bool {
+	files, err := w.configFiles()
+	if err != nil {
+		return true
+	}
+	for _, file := range files {
+		if _, known := w.stamps[file]; !known {
+			return true
+		}
+	}
+	for file, old := range w.stamps {
+		info, err := os.Stat(file)
+		if err != nil {
+			if old != (fileStamp{}) {
+				return true
+			}
+			continue
+		}
+		if !info.ModTime().Equal(old.modTime) || info.Size() != old.size {
+			return true
+		}
+	}
+	return false
+}
+
+// reload read all config files, and replace the stubs from dir if all are valid
+func (w *dirWatcher) reload() error {
+	files, err := w.configFiles()
+	if err != nil {
+		return w.fail(nil, nil, fmt.Errorf("Failed to read stub dir %s: %s", w.dir, err.Error()))
+	}
+	// decode all files first, so that body files they refer to are watched even if missing
+	cfgs := []Config{}
+	for _, file := range files {
+		b, err := ioutil.ReadFile(file)
+		if err == nil {
+			var cfg Config
+			cfg, err = decodeConfig(b, isYAML(file), w.dir)
+			cfgs = append(cfgs, cfg)
+		}
+		if err != nil {
+			return w.fail(files, cfgs, fmt.Errorf("Failed to read config %s: %s", file, err.Error()))
+		}
+	}
+	stubs := []Stub{}
+	for i, cfg := range cfgs {
+		for j, s := range cfg.Stubs {
+			path, msg, err := prepareStub(s.Path, s.Message)
+			if err != nil {
+				return w.fail(files, cfgs, fmt.Errorf("Failed to read config %s: stub %d (%s): %s", files[i], j+1, s.Path, err.Error()))
+			}
+			stubs = append(stubs, Stub{Path: path, Message: msg})
+		}
+	}
+	unmatched := []UnmatchedResponse{}
+	for i, cfg := range cfgs {
+		if cfg.Paths != nil {
+			err := cfg.Paths.validate()
+			if err != nil {
+				return w.fail(files, cfgs, fmt.Errorf("Failed to read config %s: paths: %s", files[i], err.Error()))
+			}
+		}
+		if cfg.Unmatched != nil {
+			u, err := prepareUnmatched(*cfg.Unmatched)
+			if err != nil {
+				return w.fail(files, cfgs, fmt.Errorf("Failed to read config %s: unmatched: %s", files[i], err.Error()))
+			}
+			unmatched = append(unmatched, u)
+		}
+	}
+	mounts := []Mount{}
+	for i, cfg := range cfgs {
+		for j, mt := range cfg.Mounts {
+			mt, err := prepareMount(mt)
+			if err != nil {
+				return w.fail(files, cfgs, fmt.Errorf("Failed to read config %s: mount %d (%s): %s", files[i], j+1, mt.Prefix, err.Error()))
+			}
+			mounts = append(mounts, mt)
+		}
+	}
+	for _, cfg := range cfgs {
+		if cfg.Paths != nil {
+			w.m.store.setPaths(*cfg.Paths)
+		}
+	}
+	w.m.store.replaceSource(w.dir, stubs)
+	headers := map[string]string{}
+	for _, cfg := range cfgs {
+		for k, v := range cfg.Headers {
+			headers[k] = v
+		}
+	}
+	w.m.store.replaceSourceHeaders(w.dir, headers)
+	for _, cfg := range cfgs {
+		if cfg.AllowPUT != nil {
+			w.m.store.setAllowPUT(*cfg.AllowPUT)
+		}
+	}
+	for _, u := range unmatched {
+		w.m.store.setUnmatched(u)
+	}
+	w.m.mounts.replaceSource(w.dir, mounts)
+	logf(LogInfo, "\nLoaded %d stubs from %s\n", len(stubs), w.dir)
+	w.mu.Lock()
+	w.stamps = w.snapshot(files, cfgs)
+	w.err = nil
+	w.mu.Unlock()
+	return nil
+}
+
+// fail record err as the latest reload error; files are stamped so the reload is retried only on the next change
+func (w *dirWatcher) fail(files []string, cfgs []Config, err error) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.stamps = w.snapshot(files, cfgs)
+	w.err = err
+	return err
+}
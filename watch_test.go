@@ -0,0 +1,66 @@
+package mutux
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatchReloadKeepsIDs(t *testing.T) {
+	quiet(t)
+	dir, err := ioutil.TempDir("", "mutux-watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name, content string) {
+		err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("a.json", `{"stubs":[{"path":"/a","message":"a"}]}`)
+	write("b.json", `{"headers":{"X-W":"w"},"mounts":[{"prefix":"/static","dir":"."}],`+
+		`"stubs":[{"path":"/b","responses":[{"message":"1"},{"message":"2"}]},{"path":"/c","message":"c"}]}`)
+
+	m, err := NewMutuxWithAddr("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop()
+	err = m.WatchDir(dir, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := map[string]string{}
+	for _, stub := range m.Stubs() {
+		ids[stub.Path] = stub.ID
+	}
+	m.store.nextResponse(ids["/b"])
+
+	write("b.json", `{"stubs":[{"path":"/b","responses":[{"message":"1"},{"message":"3"}]}]}`)
+	err = m.watchers[0].reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stubs := m.Stubs()
+	if len(stubs) != 2 {
+		t.Fatalf("got %d stubs after reload, want 2", len(stubs))
+	}
+	for _, stub := range stubs {
+		if stub.ID != ids[stub.Path] {
+			t.Errorf("stub %s changed ID from %s to %s", stub.Path, ids[stub.Path], stub.ID)
+		}
+		if stub.Path == "/b" && (stub.Position != 1 || *stub.Responses[1].Msg != "3") {
+			t.Errorf("stub /b = position %d, second response %q; want position 1 and the new response", stub.Position, *stub.Responses[1].Msg)
+		}
+	}
+	if _, ok := m.Headers()["X-W"]; ok {
+		t.Error("header X-W removed from b.json is still set")
+	}
+	if len(m.Mounts()) != 0 {
+		t.Errorf("mounts removed from b.json are still set: %v", m.Mounts())
+	}
+}
@@ -0,0 +1,238 @@
+package mutux
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// xmlNode element of a parsed XML document; names are compared without namespace prefix
+type xmlNode struct {
+	name     string
+	attrs    map[string]string
+	children []*xmlNode
+	text     bytes.Buffer
+}
+
+// parseXML parse body into a tree, returning a document node whose child is the root element
+func parseXML(body []byte) (*xmlNode, error) {
+	doc := &xmlNode{}
+	stack := []*xmlNode{doc}
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			node := &xmlNode{name: t.Name.Local, attrs: map[string]string{}}
+			for _, a := range t.Attr {
+				node.attrs[a.Name.Local] = a.Value
+			}
+			parent := stack[len(stack)-1]
+			parent.children = append(parent.children, node)
+			stack = append(stack, node)
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			stack[len(stack)-1].text.Write(t)
+		}
+	}
+	if len(doc.children) == 0 {
+		return nil, fmt.Errorf("no root element")
+	}
+	return doc, nil
+}
+
+// textContent text of the node and all its descendants, trimmed
+func (n *xmlNode) textContent() string {
+	var b strings.Builder
+	var walk func(*xmlNode)
+	walk = func(n *xmlNode) {
+		b.Write(n.text.Bytes())
+		for _, c := range n.children {
+			walk(c)
+		}
+	}
+	walk(n)
+	return strings.TrimSpace(b.String())
+}
+
+func (n *xmlNode) descendants() []*xmlNode {
+	nodes := []*xmlNode{}
+	for _, c := range n.children {
+		nodes = append(nodes, c)
+		nodes = append(nodes, c.descendants()...)
+	}
+	return nodes
+}
+
+// xPath compiled subset of XPath: absolute location paths of / and // steps, where a step is
+// a name or *, optionally followed by [n] or [@attr='value'] predicates, and the last step
+// may also be @attr or text()
+type xPath []xStep
+
+type xStep struct {
+	descendant bool
+	name       string
+	attr       string
+	text       bool
+	predicates []xPredicate
+}
+
+type xPredicate struct {
+	position int
+	attr     string
+	value    string
+}
+
+func parseXPath(expr string) (xPath, error) {
+	invalid := func(reason string) error {
+		return fmt.Errorf("invalid XPath %s: %s", expr, reason)
+	}
+	if !strings.HasPrefix(expr, "/") {
+		return nil, invalid("must be an absolute path")
+	}
+	path := xPath{}
+	rest := expr
+	for rest != "" {
+		step := xStep{}
+		if strings.HasPrefix(rest, "//") {
+			step.descendant = true
+			rest = rest[2:]
+		} else if strings.HasPrefix(rest, "/") {
+			rest = rest[1:]
+		} else {
+			return nil, invalid("expected /")
+		}
+		end := 0
+		depth := 0
+		for end < len(rest) && (depth > 0 || rest[end] != '/') {
+			switch rest[end] {
+			case '[':
+				depth++
+			case ']':
+				depth--
+			}
+			end++
+		}
+		token := rest[:end]
+		rest = rest[end:]
+		if i := strings.Index(token, "["); i >= 0 {
+			if !strings.HasSuffix(token, "]") || i+1 > len(token)-1 {
+				return nil, invalid("unclosed [")
+			}
+			for _, p := range strings.Split(token[i+1:len(token)-1], "][") {
+				pred, err := parseXPredicate(p)
+				if err != nil {
+					return nil, invalid(err.Error())
+				}
+				step.predicates = append(step.predicates, pred)
+			}
+			token = token[:i]
+		}
+		switch {
+		case token == "":
+			return nil, invalid("empty step")
+		case strings.Contains(token, "]"):
+			return nil, invalid("unopened ]")
+		case token == "text()":
+			step.text = true
+		case strings.HasPrefix(token, "@"):
+			step.attr = token[1:]
+		default:
+			if i := strings.Index(token, ":"); i >= 0 {
+				token = token[i+1:]
+			}
+			step.name = token
+		}
+		if (step.text || step.attr != "") && rest != "" {
+			return nil, invalid(token + " must be the last step")
+		}
+		path = append(path, step)
+	}
+	return path, nil
+}
+
+func parseXPredicate(p string) (xPredicate, error) {
+	if position, err := strconv.Atoi(p); err == nil {
+		return xPredicate{position: position}, nil
+	}
+	if strings.HasPrefix(p, "@") {
+		parts := strings.SplitN(p[1:], "=", 2)
+		if len(parts) == 2 {
+			value := strings.Trim(parts[1], `'"`)
+			return xPredicate{attr: parts[0], value: value}, nil
+		}
+	}
+	return xPredicate{}, fmt.Errorf("unsupported predicate [%s]", p)
+}
+
+// selectStrings text of selected elements, or values of selected attributes or text nodes
+func (p xPath) selectStrings(doc *xmlNode) []string {
+	current := []*xmlNode{doc}
+	for _, step := range p {
+		if step.attr != "" || step.text {
+			values := []string{}
+			for _, n := range current {
+				nodes := []*xmlNode{n}
+				if step.descendant {
+					nodes = append(nodes, n.descendants()...)
+				}
+				for _, c := range nodes {
+					if v, ok := c.attrs[step.attr]; ok && step.attr != "" {
+						values = append(values, v)
+					}
+					if step.text && c.text.Len() > 0 {
+						values = append(values, strings.TrimSpace(c.text.String()))
+					}
+				}
+			}
+			return values
+		}
+		next := []*xmlNode{}
+		for _, n := range current {
+			candidates := n.children
+			if step.descendant {
+				candidates = n.descendants()
+			}
+			matched := []*xmlNode{}
+			for _, c := range candidates {
+				if step.name == "*" || c.name == step.name {
+					matched = append(matched, c)
+				}
+			}
+			next = append(next, step.filter(matched)...)
+		}
+		current = next
+	}
+	values := []string{}
+	for _, n := range current {
+		values = append(values, n.textContent())
+	}
+	return values
+}
+
+// filter apply predicates of the step to nodes selected from one context node
+func (s xStep) filter(nodes []*xmlNode) []*xmlNode {
+	for _, pred := range s.predicates {
+		kept := []*xmlNode{}
+		for i, n := range nodes {
+			if pred.position > 0 && i+1 == pred.position {
+				kept = append(kept, n)
+			}
+			if pred.attr != "" && n.attrs[pred.attr] == pred.value {
+				kept = append(kept, n)
+			}
+		}
+		nodes = kept
+	}
+	return nodes
+}
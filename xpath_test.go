@@ -0,0 +1,71 @@
+package mutux
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseXPath(t *testing.T) {
+	doc, err := parseXML([]byte(`<soap:Envelope xmlns:soap="urn:s"><soap:Body>` +
+		`<GetUser id="7"><Name>bob</Name></GetUser>` +
+		`<GetUser id="8"><Name>alice</Name></GetUser>` +
+		`</soap:Body></soap:Envelope>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		expr string
+		want []string
+	}{
+		{"/Envelope/Body/GetUser/Name", []string{"bob", "alice"}},
+		{"/soap:Envelope/soap:Body/GetUser/@id", []string{"7", "8"}},
+		{"//GetUser/@id", []string{"7", "8"}},
+		{"//GetUser[2]/Name", []string{"alice"}},
+		{"//GetUser[@id='8']/Name/text()", []string{"alice"}},
+		{`//GetUser[@id="7"][1]/Name`, []string{"bob"}},
+		{"//*/Name", []string{"bob", "alice"}},
+		{"/Envelope/Missing", []string{}},
+	}
+	for _, test := range tests {
+		p, err := parseXPath(test.expr)
+		if err != nil {
+			t.Errorf("parseXPath(%q): %s", test.expr, err)
+			continue
+		}
+		if got := p.selectStrings(doc); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("parseXPath(%q) selected %q, want %q", test.expr, got, test.want)
+		}
+	}
+}
+
+func TestParseXPathInvalid(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"a/b",
+		"/",
+		"//",
+		"/[",
+		"//[",
+		"/a[",
+		"/a[1",
+		"/a]",
+		"/[1]",
+		"/a[]",
+		"/a[x]",
+		"/a[@id]",
+		"/a//",
+		"/@id/b",
+		"/text()/b",
+	} {
+		if _, err := parseXPath(expr); err == nil {
+			t.Errorf("parseXPath(%q) accepted", expr)
+		}
+	}
+}
+
+func TestInvalidXPathStub(t *testing.T) {
+	_, err := ParseConfig([]byte(`{"stubs":[{"path":"/soap","message":"","xPath":{"//[":"7"}}]}`), false)
+	if err == nil {
+		t.Error("ParseConfig accepted XPath //[")
+	}
+}